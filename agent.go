@@ -0,0 +1,82 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"os"
+)
+
+// ErrNoSSHAgent is returned by ExecuteRemote when no SSH agent is configured.
+var ErrNoSSHAgent = errors.New("no SSH agent configured: SSH_AUTH_SOCK is not set")
+
+// dialAgent connects to the SSH agent at SSH_AUTH_SOCK, or to the default agent of the
+// platform when it is not set.
+func dialAgent() (io.ReadWriteCloser, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		socket = defaultAgentSocket
+	}
+	if socket == "" {
+		return nil, ErrNoSSHAgent
+	}
+
+	conn, err := dialAgentSocket(socket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to reach SSH Agent at %s", socket)
+	}
+	return conn, nil
+}
+
+// WithForwardAgent forwards the local SSH agent to the remote host, like ssh -A, so remote
+// commands can authenticate with the local keys, e.g. to git clone from a private repository.
+// Only enable it for trusted hosts: anyone with root access there can use the agent too.
+func WithForwardAgent() Option {
+	return func(o *Options) {
+		o.ForwardAgent = true
+	}
+}
+
+// WithoutAgent never uses the SSH agent implicitly, e.g. for the passphrase-protected key of
+// ExecuteRemoteWithPrivateKey, so exactly the given credentials are used. ExecuteRemote,
+// which authenticates with the agent by definition, and WithForwardAgent are not affected.
+func WithoutAgent() Option {
+	return func(o *Options) {
+		o.DisableAgent = true
+	}
+}
+
+// forwardAgent serves requests of the remote host for the local agent, using a single agent
+// connection which is closed with the SSH connection.
+func (s SSHOperator) forwardAgent() error {
+	if !s.options.ForwardAgent {
+		return nil
+	}
+
+	conn, err := dialAgent()
+	if err != nil {
+		return errors.Wrap(err, "unable to forward SSH Agent")
+	}
+
+	if err := agent.ForwardToAgent(s.conn, agent.NewClient(conn)); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "unable to forward SSH Agent")
+	}
+
+	go func() {
+		s.conn.Wait()
+		conn.Close()
+	}()
+
+	return nil
+}
+
+// requestAgentForwarding enables agent forwarding for sess.
+func (s SSHOperator) requestAgentForwarding(sess *ssh.Session) error {
+	if !s.options.ForwardAgent {
+		return nil
+	}
+
+	return errors.Wrap(agent.RequestAgentForwarding(sess), "unable to request agent forwarding")
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package operator
+
+import (
+	"io"
+	"net"
+)
+
+// defaultAgentSocket is empty, an agent is only used when SSH_AUTH_SOCK is set.
+const defaultAgentSocket = ""
+
+func dialAgentSocket(socket string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", socket)
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package operator
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultAgentSocket is the named pipe of the Windows OpenSSH agent service, which does not
+// set SSH_AUTH_SOCK.
+const defaultAgentSocket = `\\.\pipe\openssh-ssh-agent`
+
+// dialAgentSocket opens a named pipe like the default agent socket, and dials any other path
+// as a Unix domain socket.
+func dialAgentSocket(socket string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(socket, `\\.\pipe\`) {
+		return os.OpenFile(socket, os.O_RDWR, 0)
+	}
+	return net.Dial("unix", socket)
+}
@@ -0,0 +1,78 @@
+package operator
+
+import (
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// WithCiphers restricts the cipher algorithms offered to the server, in order of preference,
+// e.g. "aes128-cbc" for legacy appliances or only AEAD ciphers to harden connections.
+func WithCiphers(ciphers ...string) Option {
+	return func(o *Options) {
+		o.Ciphers = ciphers
+	}
+}
+
+// WithKeyExchanges restricts the key exchange algorithms offered to the server, in order of preference.
+func WithKeyExchanges(keyExchanges ...string) Option {
+	return func(o *Options) {
+		o.KeyExchanges = keyExchanges
+	}
+}
+
+// WithMACs restricts the MAC algorithms offered to the server, in order of preference.
+func WithMACs(macs ...string) Option {
+	return func(o *Options) {
+		o.MACs = macs
+	}
+}
+
+// WithHostKeyAlgorithms restricts the host key algorithms accepted from the server, in order of
+// preference, e.g. "ssh-rsa" for old devices which only have an RSA host key.
+func WithHostKeyAlgorithms(algorithms ...string) Option {
+	return func(o *Options) {
+		o.HostKeyAlgorithms = algorithms
+	}
+}
+
+// WithLegacyFallback retries a handshake which failed because client and server have no
+// algorithm in common once more with the deprecated ssh-rsa host key algorithm, the
+// aes128-cbc cipher and the diffie-hellman-group1-sha1 key exchange enabled. These are
+// insecure, so a warning is printed whenever the fallback is used.
+func WithLegacyFallback() Option {
+	return func(o *Options) {
+		o.LegacyFallback = true
+	}
+}
+
+// legacyFallback dials with config, and when LegacyFallback is set and there was no common
+// algorithm, once more with the legacy algorithms added to those of config.
+func (o *Options) legacyFallback(address string, config *ssh.ClientConfig, dial func(config *ssh.ClientConfig) (*ssh.Client, error)) (*ssh.Client, error) {
+	client, err := dial(config)
+	if err == nil || !o.LegacyFallback || !strings.Contains(err.Error(), "no common algorithm") {
+		return client, err
+	}
+
+	warnf("%s: %s, retrying with the insecure legacy algorithms ssh-rsa, aes128-cbc and diffie-hellman-group1-sha1", address, err)
+
+	legacy := *config
+	legacy.SetDefaults()
+	legacy.Ciphers = appendMissing(legacy.Ciphers, "aes128-cbc")
+	legacy.KeyExchanges = appendMissing(legacy.KeyExchanges, "diffie-hellman-group1-sha1")
+	if len(legacy.HostKeyAlgorithms) > 0 {
+		legacy.HostKeyAlgorithms = appendMissing(legacy.HostKeyAlgorithms, ssh.KeyAlgoRSA)
+	}
+
+	return dial(&legacy)
+}
+
+// appendMissing returns a copy of algorithms with algorithm added at the end, unless it is
+// already included.
+func appendMissing(algorithms []string, algorithm string) []string {
+	for _, a := range algorithms {
+		if a == algorithm {
+			return algorithms
+		}
+	}
+	return append(append([]string{}, algorithms...), algorithm)
+}
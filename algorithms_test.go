@@ -0,0 +1,24 @@
+package operator_test
+
+import (
+	"github.com/jsiebens/operator"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+// TestWithCiphers offers only aes128-cbc, which the test server does not enable, so the
+// handshake fails for want of a common cipher unless the option never reached it.
+func TestWithCiphers(t *testing.T) {
+	host, port, stop := startTestServer(t)
+	defer stop()
+
+	op, err := operator.Dial(host, port, "test", ssh.Password("test"), operator.WithCiphers("aes128-cbc"))
+	if err == nil {
+		op.Close()
+		t.Fatal("expected the handshake to fail without a common cipher")
+	}
+	if !strings.Contains(err.Error(), "no common algorithm") {
+		t.Fatalf("expected no common algorithm, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package operator
+
+// executeAll runs commands in order with execute. With stopOnError, it stops at the first
+// failing command and returns its error; otherwise all commands run and the failures are
+// collected in a *BatchError.
+func executeAll(execute func(command string) (CommandRes, error), commands []string, stopOnError bool) ([]CommandRes, error) {
+	results := make([]CommandRes, 0, len(commands))
+	errs := make([]error, len(commands))
+	failed := false
+
+	for i, command := range commands {
+		res, err := execute(command)
+		results = append(results, res)
+		if err == nil {
+			continue
+		}
+		if stopOnError {
+			return results, err
+		}
+		errs[i] = err
+		failed = true
+	}
+
+	if failed {
+		return results, &BatchError{Commands: commands, Errors: errs}
+	}
+	return results, nil
+}
@@ -0,0 +1,82 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// WithCertificate presents the OpenSSH certificate at certPath together with the private key,
+// instead of the "-cert.pub" file that is picked up automatically next to the key.
+func WithCertificate(certPath string) Option {
+	return func(o *Options) {
+		o.CertificateFile = certPath
+	}
+}
+
+// certificateSigner combines key with the certificate at certPath, or with the certificate
+// next to the private key file when certPath is empty. Without such a certificate, key is
+// returned as is. privateKey is empty for keys that were not read from a file.
+func certificateSigner(key ssh.Signer, privateKey string, user string, certPath string) (ssh.Signer, error) {
+	explicit := certPath != ""
+	if !explicit && privateKey == "" {
+		return key, nil
+	}
+	if !explicit {
+		certPath = privateKey + "-cert.pub"
+	}
+
+	buffer, err := ioutil.ReadFile(expandPath(certPath))
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return key, nil
+		}
+		return nil, errors.Wrapf(err, "unable to read certificate: %s", certPath)
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(buffer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse certificate: %s", certPath)
+	}
+
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok || cert.CertType != ssh.UserCert {
+		return nil, errors.Errorf("not an ssh user certificate: %s", certPath)
+	}
+
+	if !validPrincipal(cert, user) {
+		return nil, errors.Errorf("certificate %s is not valid for user %s, principals are: %s", certPath, user, strings.Join(cert.ValidPrincipals, ", "))
+	}
+
+	now := uint64(time.Now().Unix())
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		warnf("certificate %s expired at %s", certPath, time.Unix(int64(cert.ValidBefore), 0))
+	}
+	if now < cert.ValidAfter {
+		warnf("certificate %s is not valid before %s", certPath, time.Unix(int64(cert.ValidAfter), 0))
+	}
+
+	signer, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "certificate %s does not match the private key: %s", certPath, privateKey)
+	}
+
+	return signer, nil
+}
+
+// validPrincipal reports whether cert can be used to log in as user. A certificate without
+// principals is valid for any user.
+func validPrincipal(cert *ssh.Certificate, user string) bool {
+	if len(cert.ValidPrincipals) == 0 {
+		return true
+	}
+	for _, principal := range cert.ValidPrincipals {
+		if principal == user {
+			return true
+		}
+	}
+	return false
+}
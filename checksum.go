@@ -0,0 +1,93 @@
+package operator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// verifyChecksum compares the SHA-256 checksum of the local file at path with the one
+// of remotePath, as computed by remoteSum.
+func verifyChecksum(path string, remotePath string, remoteSum func(string) (string, error)) error {
+	want, err := fileSHA256(expandPath(path))
+	if err != nil {
+		return err
+	}
+
+	got, err := remoteSum(remotePath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to compute checksum of %s", remotePath)
+	}
+
+	if got != want {
+		return errors.Errorf("checksum mismatch for %s: local file has sha256 %s, remote file has %s", remotePath, want, got)
+	}
+
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return readSHA256(file)
+}
+
+func readSHA256(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sha256Tools are the commands tried, in order, to compute a checksum on the remote host.
+var sha256Tools = []string{"sha256sum", "shasum -a 256"}
+
+// remoteSHA256 computes the checksum of remotePath with sha256sum or shasum. When neither is
+// installed, or the remote host runs Windows, the file is read back over SFTP instead.
+func (s SSHOperator) remoteSHA256(remotePath string) (string, error) {
+	if !s.options.Shell.windows() {
+		for _, tool := range sha256Tools {
+			stdout := bytes.Buffer{}
+			stderr := bytes.Buffer{}
+
+			res, err := s.execute(tool+" -- "+shellQuote(remotePath), nil, &stdout, &stderr)
+			if res.ExitCode == 127 {
+				// command not found
+				continue
+			}
+			if err != nil {
+				return "", errors.Wrapf(err, "%s failed: %s", tool, strings.TrimSpace(stderr.String()))
+			}
+
+			return parseChecksum(tool, stdout.String())
+		}
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.Download(remotePath, writer))
+	}()
+	sum, err := readSHA256(reader)
+	reader.Close()
+
+	return sum, err
+}
+
+// parseChecksum returns the hash of the output of sha256sum or shasum. GNU sha256sum prefixes
+// the line with a backslash when the file name contains a backslash or a newline.
+func parseChecksum(tool string, output string) (string, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", errors.Errorf("%s returned no checksum", tool)
+	}
+	return strings.TrimPrefix(fields[0], "\\"), nil
+}
@@ -0,0 +1,29 @@
+package operator
+
+import "testing"
+
+func TestParseChecksum(t *testing.T) {
+	const hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	for _, test := range []struct {
+		output string
+		want   string
+	}{
+		{hash + "  /tmp/file\n", hash},
+		{hash + " */tmp/file\n", hash},
+		{"\\" + hash + "  /tmp/back\\\\slash\n", hash},
+		{"\\" + hash + "  /tmp/new\\nline\n", hash},
+	} {
+		got, err := parseChecksum("sha256sum", test.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("parsing %q: expected %s, got %s", test.output, test.want, got)
+		}
+	}
+
+	if _, err := parseChecksum("sha256sum", ""); err == nil {
+		t.Error("expected an error for empty output")
+	}
+}
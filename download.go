@@ -0,0 +1,15 @@
+package operator
+
+// DownloadOptions configures DownloadWithOptions and DownloadFileWithOptions.
+type DownloadOptions struct {
+	// Progress, when set, is called periodically while the file is downloaded.
+	Progress ProgressFunc
+}
+
+// WithReadFileLimit makes ReadFile refuse files larger than limit bytes, so reading a huge
+// file by mistake cannot exhaust the memory. A limit of zero, the default, means no limit.
+func WithReadFileLimit(limit int64) Option {
+	return func(o *Options) {
+		o.ReadFileLimit = limit
+	}
+}
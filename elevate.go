@@ -0,0 +1,196 @@
+package operator
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Elevation is the tool ExecuteSudo, and chown with UploadOptions.Sudo, use to run commands
+// as root. Any value other than the predefined ones is used as a command prefix, e.g.
+// "pkexec" or "run0". Such tools authenticate on their own, like with a polkit rule, so
+// running a command with one fails when a password is given.
+type Elevation string
+
+const (
+	// ElevateSudo runs commands with sudo, which reads the password from its standard input.
+	// It is the default.
+	ElevateSudo Elevation = "sudo"
+	// ElevateDoas runs commands with doas, as found on OpenBSD and hardened Linux hosts.
+	ElevateDoas Elevation = "doas"
+	// ElevateSu runs commands with su, which needs the password of root.
+	ElevateSu Elevation = "su"
+)
+
+// WithElevation selects how commands are run as root, for hosts without sudo. doas and su
+// only read the password from a terminal, so an SSHOperator answers their password prompt on a
+// pseudo-terminal; with a LocalOperator, they can only be used without a password.
+func WithElevation(elevation Elevation) Option {
+	return func(o *Options) {
+		o.Elevation = elevation
+	}
+}
+
+// elevator runs commands as root with an Elevation.
+type elevator struct {
+	elevation Elevation
+	execute   executeFunc
+	// terminal runs a command on a pseudo-terminal, and is nil when there is none.
+	terminal executeFunc
+	// limit caps the buffered output like Options.MaxOutputBytes.
+	limit int64
+}
+
+// run runs command as root, authenticating with password.
+func (e elevator) run(command string, password string) (CommandRes, error) {
+	script := shellQuote("exec </dev/null; " + command)
+
+	switch e.elevation {
+	case "", ElevateSudo:
+		return executeSudo(e.execute, command, password, e.limit)
+	case ElevateDoas:
+		return e.prompted("doas -- sh -c "+script, command, password)
+	case ElevateSu:
+		return e.prompted("su root -c "+script, command, password)
+	default:
+		if password != "" {
+			return CommandRes{}, errors.Errorf("unable to run %s as root: %s does not read a password, run it without one", command, e.elevation)
+		}
+		return e.runWithoutPassword(command)
+	}
+}
+
+// runWithoutPassword runs command as root, assuming no password is needed, e.g. because of a
+// NOPASSWD rule for sudo or a nopass rule for doas.
+func (e elevator) runWithoutPassword(command string) (CommandRes, error) {
+	script := shellQuote(command)
+
+	switch e.elevation {
+	case "", ElevateSudo:
+		return e.buffered("sudo -n -- sh -c "+script, command, nil)
+	case ElevateDoas:
+		return e.buffered("doas -n -- sh -c "+script, command, nil)
+	case ElevateSu:
+		return e.buffered("su root -c "+script, command, nil)
+	default:
+		return e.buffered(string(e.elevation)+" sh -c "+script, command, nil)
+	}
+}
+
+// buffered runs line, which runs command, and returns its buffered output.
+func (e elevator) buffered(line string, command string, stdin io.Reader) (CommandRes, error) {
+	stdout := outputBuffer{limit: e.limit}
+	stderr := outputBuffer{limit: e.limit}
+
+	res, err := e.execute(line, stdin, io.MultiWriter(os.Stdout, &stdout), io.MultiWriter(os.Stderr, &stderr))
+
+	res.StdOut = stdout.Bytes()
+	res.StdErr = stderr.Bytes()
+	res.Truncated = stdout.truncated || stderr.truncated
+
+	return res, withStderr(err, command, res.StdErr)
+}
+
+// prompted runs line, which runs command, on a pseudo-terminal, and answers the password
+// prompt on it with password. As a terminal has a single output stream, all output of the
+// command ends up in StdOut.
+func (e elevator) prompted(line string, command string, password string) (CommandRes, error) {
+	if e.terminal == nil {
+		return CommandRes{}, errors.Errorf("unable to run %s as root: %s reads the password from a terminal, which is not available", command, e.elevation)
+	}
+
+	stdout := outputBuffer{limit: e.limit}
+	stdin, answers := io.Pipe()
+	prompt := &passwordAnswerer{w: io.MultiWriter(os.Stdout, &stdout), answers: answers, password: password}
+
+	res, err := e.terminal(line, stdin, prompt, prompt)
+	answers.Close()
+	prompt.flush()
+
+	res.StdOut = stdout.Bytes()
+	res.Truncated = stdout.truncated
+
+	if err != nil && prompt.rejected() {
+		return res, ErrIncorrectSudoPassword
+	}
+
+	return res, withStderr(err, command, nil)
+}
+
+// passwordPromptPattern matches the password prompts of doas and su, e.g.
+// "doas (deploy@host) password: " and "Password: ".
+var passwordPromptPattern = regexp.MustCompile(`(?i)password[^\n]*: ?$`)
+
+// passwordRejectedPattern matches the message of doas and su for a wrong password.
+var passwordRejectedPattern = regexp.MustCompile(`(?i)^\s*(doas|su): authentication fail`)
+
+// passwordAnswerer writes the output of a command to w, except for the first password prompt,
+// which it answers with password instead. Output is passed on line by line until the prompt
+// was answered, since the prompt is not terminated by a newline.
+type passwordAnswerer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	answers  *io.PipeWriter
+	password string
+	pending  []byte
+	answered bool
+	// reply holds the start of the output following the answer.
+	reply []byte
+}
+
+func (p *passwordAnswerer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.answered {
+		out := b
+		if len(p.reply) == 0 {
+			// the newline printed in place of the password, which is not echoed
+			out = bytes.TrimPrefix(bytes.TrimPrefix(out, []byte("\r")), []byte("\n"))
+		}
+		if len(p.reply) < maxPromptTail {
+			p.reply = append(p.reply, b...)
+		}
+		if _, err := p.w.Write(out); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	p.pending = append(p.pending, b...)
+	if i := bytes.LastIndexByte(p.pending, '\n'); i >= 0 {
+		if _, err := p.w.Write(p.pending[:i+1]); err != nil {
+			return 0, err
+		}
+		p.pending = append([]byte(nil), p.pending[i+1:]...)
+	}
+
+	if passwordPromptPattern.Match(p.pending) {
+		p.pending = nil
+		p.answered = true
+		// written concurrently, as the pipe blocks until the session reads the answer
+		go p.answers.Write([]byte(p.password + "\n"))
+	}
+
+	return len(b), nil
+}
+
+// flush writes output held back while waiting for a prompt which never came.
+func (p *passwordAnswerer) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.w.Write(p.pending)
+	p.pending = nil
+}
+
+// rejected reports whether the password was answered and then rejected.
+func (p *passwordAnswerer) rejected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.answered && passwordRejectedPattern.Match(p.reply)
+}
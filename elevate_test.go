@@ -0,0 +1,22 @@
+package operator_test
+
+import (
+	"github.com/jsiebens/operator"
+	"testing"
+)
+
+func TestCustomElevation(t *testing.T) {
+	op := operator.NewLocalOperator(operator.WithElevation("env"))
+
+	res, err := op.ExecuteSudo("echo elevated", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.StdOut); got != "elevated\n" {
+		t.Errorf("expected output elevated, got %q", got)
+	}
+
+	if _, err := op.ExecuteSudo("echo elevated", "secret"); err == nil {
+		t.Error("expected an error when passing a password to a custom elevation")
+	}
+}
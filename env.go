@@ -0,0 +1,110 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// EnvStrategy controls how environment variables are passed to remote commands.
+type EnvStrategy int
+
+const (
+	// EnvAuto sends every variable with a setenv request, and exports the ones the server
+	// refuses in the command line instead. This is the default.
+	EnvAuto EnvStrategy = iota
+	// EnvSetenv only sends setenv requests. Most SSH servers refuse variables that are not
+	// listed in their AcceptEnv setting, in which case the command fails. Values never appear
+	// in the command line.
+	EnvSetenv
+	// EnvInline sets the variables at the start of the command line, using the syntax of the
+	// configured Shell. This works with any server, but the values become part of the command,
+	// so they can show up in the remote process list and in logs.
+	EnvInline
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// WithEnv sets environment variables for every command. Calling it more than once adds to
+// the variables set before.
+func WithEnv(env map[string]string) Option {
+	return func(o *Options) {
+		if o.Env == nil {
+			o.Env = map[string]string{}
+		}
+		for name, value := range env {
+			o.Env[name] = value
+		}
+	}
+}
+
+// WithEnvStrategy selects how environment variables are passed to remote commands.
+func WithEnvStrategy(strategy EnvStrategy) Option {
+	return func(o *Options) {
+		o.EnvStrategy = strategy
+	}
+}
+
+func (o *Options) envNames() []string {
+	names := make([]string, 0, len(o.Env))
+	for name := range o.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// remoteCommand prepares sess and returns the command line to run in it: environment
+// variables that could not be sent with setenv are set inline, the working directory is
+// changed, and the result is wrapped in the configured shell.
+func (o *Options) remoteCommand(sess *ssh.Session, command string) (string, error) {
+	inline := ""
+
+	for _, name := range o.envNames() {
+		if !envNamePattern.MatchString(name) {
+			return "", errors.Errorf("invalid environment variable name: %q", name)
+		}
+
+		if o.EnvStrategy != EnvInline {
+			err := sess.Setenv(name, o.Env[name])
+			if err == nil {
+				continue
+			}
+			if o.EnvStrategy == EnvSetenv {
+				return "", errors.Wrapf(err, "server refused environment variable %s", name)
+			}
+		}
+
+		inline += o.Shell.setEnv(name, o.Env[name])
+	}
+
+	if o.WorkingDir != "" {
+		inline += o.Shell.changeDir(o.WorkingDir)
+	}
+
+	if o.Shell == ShellExec {
+		args, err := splitArgs(command)
+		if err != nil {
+			return "", err
+		}
+		command = quoteArgs(args)
+	}
+
+	return o.Shell.wrap(inline + command), nil
+}
+
+// environ returns the environment for local commands: the current environment
+// with the configured variables added, or nil when there are none.
+func (o *Options) environ() []string {
+	if len(o.Env) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	for _, name := range o.envNames() {
+		env = append(env, name+"="+o.Env[name])
+	}
+	return env
+}
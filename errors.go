@@ -0,0 +1,226 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// DialError is returned when the connection to a host cannot be established, e.g. because
+// the host is unreachable, the connection timed out or the host key was rejected.
+type DialError struct {
+	Address string
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("unable to connect to %s over ssh: %s", e.Address, e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when the server rejects all offered authentication methods.
+type AuthError struct {
+	User    string
+	Address string
+	Err     error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("unable to authenticate as %s to %s: %s", e.User, e.Address, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// CommandError is returned when a command exits with a non-zero status or is terminated by a
+// signal. The output the command produced until then is still returned in its CommandRes.
+type CommandError struct {
+	Command string
+	// ExitCode is the exit status of the command, or -1 when it was terminated by a signal or
+	// the server did not report an exit status.
+	ExitCode int
+	// Signal is the name of the signal that terminated the command, if any.
+	Signal string
+	// Stderr holds the standard error of the command. It is empty for ExecuteStream and
+	// ExecuteCombined, which do not buffer the standard error separately. The end of it is
+	// included in the error message.
+	Stderr []byte
+	// Err is the underlying *ssh.ExitError, *ssh.ExitMissingError or *exec.ExitError.
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	message := fmt.Sprintf("command failed: %s: %s", e.Command, e.Err)
+	if stderr := stderrSnippet(e.Stderr); stderr != "" {
+		message += ": " + stderr
+	}
+	return message
+}
+
+// maxStderrSnippet is the maximum number of bytes of stderr included in the message of a CommandError.
+const maxStderrSnippet = 512
+
+// stderrSnippet returns the end of stderr, where the reason of a failure is usually found,
+// on a single line.
+func stderrSnippet(stderr []byte) string {
+	snippet := strings.TrimSpace(string(stderr))
+	if len(snippet) > maxStderrSnippet {
+		start := len(snippet) - maxStderrSnippet
+		for start < len(snippet) && !utf8.RuneStart(snippet[start]) {
+			start++
+		}
+		snippet = "..." + snippet[start:]
+	}
+
+	var lines []string
+	for _, line := range strings.Split(snippet, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "; ")
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// DeadlineError is returned by ExecuteUntil when the predicate did not accept the result of
+// the command before the deadline.
+type DeadlineError struct {
+	Command  string
+	Attempts int
+	// Err is the error of the last attempt, which is nil when the command succeeded but its
+	// result was not accepted.
+	Err error
+}
+
+func (e *DeadlineError) Error() string {
+	message := fmt.Sprintf("condition not met after %d attempts: %s", e.Attempts, e.Command)
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *DeadlineError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned by ExecuteTimeout when a command does not finish within its timeout.
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+	// Stdout and Stderr hold the output the command produced before it was killed. The end
+	// of it is included in the error message.
+	Stdout []byte
+	Stderr []byte
+}
+
+func (e *TimeoutError) Error() string {
+	message := fmt.Sprintf("command timed out after %s: %s", e.Timeout, e.Command)
+	output := stderrSnippet(e.Stderr)
+	if output == "" {
+		output = stderrSnippet(e.Stdout)
+	}
+	if output != "" {
+		message += ": " + output
+	}
+	return message
+}
+
+// Unwrap makes a TimeoutError match context.DeadlineExceeded with errors.Is.
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// BatchError is returned by ExecuteAll when commands failed while it was not stopping at the
+// first failure.
+type BatchError struct {
+	Commands []string
+	// Errors holds the error of every command, at the index of the command; it is nil for the
+	// commands which succeeded.
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d commands failed, first: %s", failed, len(e.Commands), first)
+}
+
+// Unwrap returns the error of the first failed command, e.g. to match it with errors.As.
+func (e *BatchError) Unwrap() error {
+	for _, err := range e.Errors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiskFullError is returned when an upload fails because the file system of the destination
+// has no space left.
+type DiskFullError struct {
+	Path string
+	Err  error
+}
+
+func (e *DiskFullError) Error() string {
+	return fmt.Sprintf("unable to write %s: no space left on device: %s", e.Path, e.Err)
+}
+
+func (e *DiskFullError) Unwrap() error {
+	return e.Err
+}
+
+// diskFullError reports err as a DiskFullError for path when it is caused by a full disk.
+func diskFullError(path string, err error) error {
+	if isDiskFull(err) {
+		return &DiskFullError{Path: path, Err: err}
+	}
+	return err
+}
+
+// isDiskFull reports whether err is ENOSPC, either as an error value or in the message of a
+// remote program like scp.
+func isDiskFull(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, syscall.ENOSPC) || strings.Contains(strings.ToLower(err.Error()), "no space left on device")
+}
+
+// connectError classifies a failed connection attempt to address as an AuthError or a DialError.
+func connectError(address string, user string, err error) error {
+	// the ssh package reports authentication failures as plain strings
+	if strings.Contains(err.Error(), "ssh: unable to authenticate") {
+		return &AuthError{User: user, Address: address, Err: err}
+	}
+	return &DialError{Address: address, Err: err}
+}
+
+// withStderr adds stderr, and the command as given by the caller, to a CommandError.
+func withStderr(err error, command string, stderr []byte) error {
+	if commandErr, ok := err.(*CommandError); ok {
+		commandErr.Command = command
+		commandErr.Stderr = stderr
+	}
+	return err
+}
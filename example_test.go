@@ -0,0 +1,40 @@
+package operator_test
+
+import (
+	"github.com/jsiebens/operator"
+	"github.com/jsiebens/operator/operatortest"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// This example restricts the algorithms of the connection, as needed for a legacy appliance
+// which only offers "aes128-cbc".
+func ExampleWithCiphers() {
+	// a server which, like many legacy appliances, only offers aes128-cbc
+	address, stop, err := operatortest.StartTestServer(operatortest.WithCiphers("aes128-cbc"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+
+	host, portString, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portString)
+
+	op, err := operator.Dial(host, port, "test", ssh.Password("test"),
+		operator.WithCiphers("aes128-cbc"),
+		operator.WithKeyExchanges("curve25519-sha256@libssh.org"),
+		operator.WithMACs("hmac-sha2-256"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer op.Close()
+
+	if _, err := op.ExecuteStream("echo connected", os.Stdout, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+	// Output: connected
+}
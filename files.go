@@ -0,0 +1,202 @@
+package operator
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// downloadFile writes remotePath to localPath, removing the partially written
+// local file when the download fails.
+func downloadFile(op CommandOperator, remotePath string, localPath string, opts DownloadOptions) error {
+	path := expandPath(localPath)
+
+	destination, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = op.DownloadWithOptions(remotePath, destination, opts)
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// readFile reads remotePath into memory with op. A positive limit is the maximum size in bytes.
+func readFile(op CommandOperator, remotePath string, limit int64) ([]byte, error) {
+	info, err := op.Stat(remotePath)
+	if errors.Is(err, ErrSFTPUnavailable) {
+		// the size is unknown without SFTP, the limit is only enforced while reading
+		buffer := &limitedBuffer{limit: limit}
+		if err := op.Download(remotePath, buffer); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.Errorf("unable to read %s: is a directory", remotePath)
+	}
+	if limit > 0 && info.Size() > limit {
+		return nil, errors.Errorf("unable to read %s: file size of %d bytes exceeds the limit of %d bytes", remotePath, info.Size(), limit)
+	}
+
+	// the file may grow after the stat, so the limit is enforced while reading as well
+	buffer := &limitedBuffer{limit: limit}
+	buffer.Grow(int(info.Size()))
+	if err := op.Download(remotePath, buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && int64(b.Len()+len(p)) > b.limit {
+		return 0, errors.Errorf("file size exceeds the limit of %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// remotePathError reports err as an *os.PathError, so a missing remote file can be
+// detected with os.IsNotExist like a missing local one.
+func remotePathError(op string, path string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	}
+	if errors.Is(err, os.ErrExist) {
+		return &os.PathError{Op: op, Path: path, Err: os.ErrExist}
+	}
+	return errors.Wrapf(err, "%s %s", op, path)
+}
+
+func exists(_ os.FileInfo, err error) (bool, error) {
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func isDir(info os.FileInfo, err error) (bool, error) {
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// mkdirAll creates dir and any missing parents with mkdir, returning nil when dir already
+// exists. parent returns the parent directory of a path.
+func mkdirAll(dir string, stat func(string) (os.FileInfo, error), mkdir func(string) error, parent func(string) string) error {
+	info, err := stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	if p := parent(dir); p != dir {
+		if err := mkdirAll(p, stat, mkdir, parent); err != nil {
+			return err
+		}
+	}
+
+	return mkdir(dir)
+}
+
+// ParseMode parses an octal permission string such as "0644", as taken by the upload methods.
+func ParseMode(mode string) (os.FileMode, error) {
+	permissions, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid file mode: %s", mode)
+	}
+	return os.FileMode(permissions), nil
+}
+
+// uploadDir walks localDir and recreates it below remoteDir, calling mkdir for every
+// directory and upload for every regular file. Symbolic links and other non-regular
+// files are skipped with a warning.
+func uploadDir(localDir string, remoteDir string, mkdir func(remotePath string) error, upload func(path string, remotePath string) error) error {
+	root := expandPath(localDir)
+
+	return filepath.Walk(root, func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remoteDir, filepath.ToSlash(rel))
+
+		switch {
+		case info.IsDir():
+			return mkdir(target)
+		case info.Mode().IsRegular():
+			return upload(current, target)
+		default:
+			warnf("skipping %s: not a regular file", current)
+			return nil
+		}
+	})
+}
+
+// uploadGlob calls upload for every file matching pattern, with a remote path in remoteDir,
+// and returns the remote paths of the uploaded files. Directories are skipped with a warning.
+func uploadGlob(pattern string, remoteDir string, upload func(path string, remotePath string) error) ([]string, error) {
+	matches, err := filepath.Glob(expandPath(pattern))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern: %s", pattern)
+	}
+
+	uploaded := []string{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return uploaded, err
+		}
+		if !info.Mode().IsRegular() {
+			warnf("skipping %s: not a regular file", match)
+			continue
+		}
+
+		remotePath := path.Join(remoteDir, filepath.Base(match))
+		if err := upload(match, remotePath); err != nil {
+			return uploaded, err
+		}
+		uploaded = append(uploaded, remotePath)
+	}
+
+	return uploaded, nil
+}
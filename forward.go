@@ -0,0 +1,195 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Tunnel is a local port forwarded over SSH with ForwardLocal.
+type Tunnel struct {
+	t *tunnel
+}
+
+// Addr returns the local address the tunnel listens on, e.g. to find the port chosen for a
+// localAddr like "127.0.0.1:0".
+func (t *Tunnel) Addr() net.Addr {
+	return t.t.Addr()
+}
+
+// Close stops listening and closes the forwarded connections still open.
+func (t *Tunnel) Close() error {
+	return t.t.Close()
+}
+
+// ForwardLocal listens on localAddr and forwards every accepted connection to remoteAddr
+// through the SSH connection, like ssh -L. remoteAddr is resolved by the remote host, so it
+// can point to services only reachable from there. Closing the returned tunnel closes the
+// connections still open as well; it is also closed together with the operator.
+func (s SSHOperator) ForwardLocal(localAddr string, remoteAddr string) (*Tunnel, error) {
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on %s", localAddr)
+	}
+
+	t := newTunnel(listener, func() (net.Conn, error) {
+		return s.conn.Dial("tcp", remoteAddr)
+	})
+
+	s.closeWith(t)
+
+	return &Tunnel{t: t}, nil
+}
+
+// ForwardRemote listens on remoteAddr on the remote host and forwards every connection
+// accepted there to localAddr, like ssh -R. The server must allow TCP forwarding. The
+// remote listener is torn down when the operator is closed, even when the operator does not
+// own its client.
+func (s SSHOperator) ForwardRemote(remoteAddr string, localAddr string) error {
+	listener, err := s.conn.Listen("tcp", remoteAddr)
+	if err != nil {
+		return errors.Wrapf(err, "unable to listen on %s on the remote host", remoteAddr)
+	}
+
+	t := newTunnel(listener, func() (net.Conn, error) {
+		return net.Dial("tcp", localAddr)
+	})
+
+	s.closeWith(t)
+
+	return nil
+}
+
+// closeWith closes t when the operator is closed or its connection is lost. As the connection
+// stays open when it is borrowed from the caller, t is closed explicitly rather than with it.
+func (s SSHOperator) closeWith(t *tunnel) {
+	lost := make(chan struct{})
+	go func() {
+		s.conn.Wait()
+		close(lost)
+	}()
+
+	go func() {
+		select {
+		case <-s.closed.done:
+		case <-lost:
+		}
+		t.Close()
+	}()
+}
+
+// tunnel is a listener which proxies every accepted connection to a connection created by dial.
+type tunnel struct {
+	net.Listener
+	dial func() (net.Conn, error)
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newTunnel(listener net.Listener, dial func() (net.Conn, error)) *tunnel {
+	t := &tunnel{
+		Listener: listener,
+		dial:     dial,
+		conns:    map[net.Conn]struct{}{},
+	}
+	go t.serve()
+	return t
+}
+
+func (t *tunnel) serve() {
+	for {
+		conn, err := t.Listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.proxy(conn)
+	}
+}
+
+func (t *tunnel) proxy(conn net.Conn) {
+	if !t.track(conn) {
+		return
+	}
+	defer t.untrack(conn)
+
+	target, err := t.dial()
+	if err != nil {
+		warnf("unable to forward connection from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	if !t.track(target) {
+		return
+	}
+	defer t.untrack(target)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(target, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(conn, target)
+	}()
+	wg.Wait()
+}
+
+// closeWriter is implemented by *net.TCPConn and the connections of the ssh package.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// copyHalf copies src to dst, and then closes the writing side of dst only, so a peer which
+// half-closed its connection still receives the reply.
+func copyHalf(dst net.Conn, src net.Conn) {
+	io.Copy(dst, src)
+	if c, ok := dst.(closeWriter); ok {
+		c.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// track registers conn so it is closed with the tunnel. It closes conn and returns false
+// when the tunnel is already closed.
+func (t *tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		conn.Close()
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *tunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+
+	conn.Close()
+}
+
+func (t *tunnel) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	conns := t.conns
+	t.conns = map[net.Conn]struct{}{}
+	t.mu.Unlock()
+
+	err := t.Listener.Close()
+	for conn := range conns {
+		conn.Close()
+	}
+	return err
+}
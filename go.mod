@@ -3,9 +3,10 @@ module github.com/jsiebens/operator
 go 1.13
 
 require (
-	github.com/alexellis/go-execute v0.0.0-20200124154445-8697e4e28c5e
 	github.com/bramvdbogaerde/go-scp v0.0.0-20200820121624-ded9ee94aef5
+	github.com/kevinburke/ssh_config v1.2.0
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.9.1
-	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	github.com/pkg/sftp v1.13.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
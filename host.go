@@ -0,0 +1,274 @@
+package operator
+
+import (
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// HostSpec describes a remote host and the credentials used to log in to it.
+type HostSpec struct {
+	// Host is a hostname or an IPv4 or IPv6 address. It may include a port, like
+	// "example.com:2222" or "[fe80::1]:2222", which takes precedence over Port.
+	Host string
+	// Port is the SSH port of the host. Zero means port 22.
+	Port int
+	// User is the login user. When empty, the current local user is used, like ssh does.
+	User string
+	Auth []ssh.AuthMethod
+
+	// IdentityFiles lists the private keys configured for the host in the ssh config, with
+	// their percent tokens like %h and %r expanded.
+	IdentityFiles []string
+	// HostKeyAlgorithms lists the host key algorithms configured for the host in the ssh
+	// config. When set, it takes precedence over Options.HostKeyAlgorithms for this host.
+	HostKeyAlgorithms []string
+	// Jumps lists the jump hosts configured with ProxyJump in the ssh config, in the
+	// order they have to be dialed. They can be passed to DialVia or ExecuteRemoteVia.
+	Jumps []HostSpec
+	// Alias is the name ResolveHost looked up in the ssh config. When set, the Host of an
+	// operator connected to the host returns it instead of the address.
+	Alias string
+}
+
+// name identifies the host for SSHOperator.Host: its alias, or the address it is dialed at.
+func (h HostSpec) name() string {
+	if h.Alias != "" {
+		return h.Alias
+	}
+	return h.address()
+}
+
+func (h HostSpec) address() string {
+	host, port, err := splitPort(h.Host)
+	if err != nil {
+		// leave the host alone, so dialing fails with a meaningful error
+		host, port = h.Host, 0
+	}
+	if port == 0 {
+		port = h.Port
+	}
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// ResolveHost looks up alias in ~/.ssh/config and /etc/ssh/ssh_config, like the ssh command
+// does, and returns the effective HostName, Port, User, IdentityFile, HostKeyAlgorithms and ProxyJump settings.
+// Wildcard Host patterns and Include directives are taken into account; relative Include paths
+// are resolved against ~/.ssh, but a leading ~ in an Include path is not expanded. The returned HostSpec
+// has no Auth methods; the caller decides how to use the configured identity files, whose
+// percent tokens are expanded.
+func ResolveHost(alias string) (HostSpec, error) {
+	return resolveHost(alias, map[string]bool{})
+}
+
+func resolveHost(alias string, resolving map[string]bool) (HostSpec, error) {
+	if resolving[alias] {
+		return HostSpec{}, errors.Errorf("unable to resolve host %s: ProxyJump loop detected", alias)
+	}
+	resolving[alias] = true
+	defer delete(resolving, alias)
+
+	settings := ssh_config.DefaultUserSettings
+
+	hostname, err := settings.GetStrict(alias, "HostName")
+	if err != nil {
+		return HostSpec{}, errors.Wrapf(err, "unable to read ssh config for host %s", alias)
+	}
+	if hostname == "" {
+		hostname = alias
+	}
+
+	port, err := strconv.Atoi(settings.Get(alias, "Port"))
+	if err != nil {
+		return HostSpec{}, errors.Wrapf(err, "invalid Port in ssh config for host %s", alias)
+	}
+
+	spec := HostSpec{
+		Host:  strings.Replace(hostname, "%h", alias, -1),
+		Port:  port,
+		User:  settings.Get(alias, "User"),
+		Alias: alias,
+	}
+
+	for _, identityFile := range settings.GetAll(alias, "IdentityFile") {
+		// the library falls back to the obsolete SSH1 default when nothing is configured
+		if identityFile == ssh_config.Default("IdentityFile") {
+			continue
+		}
+		identityFile, err = expandTokens(identityFile, alias, spec)
+		if err != nil {
+			return HostSpec{}, errors.Wrapf(err, "invalid IdentityFile in ssh config for host %s", alias)
+		}
+		spec.IdentityFiles = append(spec.IdentityFiles, identityFile)
+	}
+
+	// the library returns the OpenSSH defaults when nothing is configured, and lists starting
+	// with +, - or ^ modify those defaults, which differ from the ones of golang.org/x/crypto/ssh
+	hostKeyAlgorithms := settings.Get(alias, "HostKeyAlgorithms")
+	if hostKeyAlgorithms != ssh_config.Default("HostKeyAlgorithms") && hostKeyAlgorithms != "" && !strings.ContainsAny(hostKeyAlgorithms[:1], "+-^") {
+		for _, algorithm := range strings.Split(hostKeyAlgorithms, ",") {
+			spec.HostKeyAlgorithms = append(spec.HostKeyAlgorithms, strings.TrimSpace(algorithm))
+		}
+	}
+
+	proxyJump := settings.Get(alias, "ProxyJump")
+	if proxyJump == "" || strings.EqualFold(proxyJump, "none") {
+		return spec, nil
+	}
+
+	for _, hop := range strings.Split(proxyJump, ",") {
+		user, host, port, err := splitTarget(strings.TrimSpace(hop))
+		if err != nil {
+			return HostSpec{}, errors.Wrapf(err, "invalid ProxyJump in ssh config for host %s", alias)
+		}
+
+		jump, err := resolveHost(host, resolving)
+		if err != nil {
+			return HostSpec{}, err
+		}
+		if user != "" {
+			jump.User = user
+		}
+		if port != 0 {
+			jump.Port = port
+		}
+
+		spec.Jumps = append(spec.Jumps, jump.Jumps...)
+		jump.Jumps = nil
+		spec.Jumps = append(spec.Jumps, jump)
+	}
+
+	return spec, nil
+}
+
+// ParseTarget parses a [user@]host[:port] target like "deploy@10.0.0.5:2222". IPv6 addresses
+// need brackets when followed by a port, like "deploy@[fe80::1]:2222". The port defaults to
+// 22, and the user to the current local user, like the ssh command does.
+func ParseTarget(target string) (user string, host string, port int, err error) {
+	user, host, port, err = splitTarget(target)
+	if err != nil {
+		return "", "", 0, errors.Wrapf(err, "invalid target %s", target)
+	}
+
+	if port == 0 {
+		port = 22
+	}
+	if user == "" {
+		if user, err = currentUser(); err != nil {
+			return "", "", 0, err
+		}
+	}
+
+	return user, host, port, nil
+}
+
+// expandTokens replaces the percent tokens of an IdentityFile path, like OpenSSH does: %h is
+// the host name, %n the alias as given, %p the port, %r the remote user, %u the local user, %d
+// the home directory of the local user, %l the local host name and %% a percent sign.
+func expandTokens(path string, alias string, spec HostSpec) (string, error) {
+	if !strings.Contains(path, "%") {
+		return path, nil
+	}
+
+	var expanded strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' {
+			expanded.WriteByte(path[i])
+			continue
+		}
+		if i++; i == len(path) {
+			return "", errors.Errorf("unterminated token in %s", path)
+		}
+
+		var value string
+		var err error
+		switch path[i] {
+		case '%':
+			value = "%"
+		case 'h':
+			value, _, _ = net.SplitHostPort(spec.address())
+		case 'n':
+			value = alias
+		case 'p':
+			_, value, _ = net.SplitHostPort(spec.address())
+		case 'r':
+			value = spec.User
+			if value == "" {
+				value, err = currentUser()
+			}
+		case 'u':
+			value, err = currentUser()
+		case 'd':
+			value, err = os.UserHomeDir()
+		case 'l':
+			value, err = os.Hostname()
+		default:
+			return "", errors.Errorf("unknown token %%%c in %s", path[i], path)
+		}
+		if err != nil {
+			return "", err
+		}
+		expanded.WriteString(value)
+	}
+
+	return expanded.String(), nil
+}
+
+// currentUser returns the name of the local user.
+func currentUser() (string, error) {
+	current, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to determine the current user")
+	}
+	return current.Username, nil
+}
+
+// splitTarget splits a [user@]host[:port] string. The port is 0 when absent.
+func splitTarget(target string) (user string, host string, port int, err error) {
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		user, target = target[:i], target[i+1:]
+	}
+
+	host, port, err = splitPort(target)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	if host == "" {
+		return "", "", 0, errors.New("missing host")
+	}
+
+	return user, host, port, nil
+}
+
+// splitPort splits an optional port off target. IPv6 addresses need brackets when followed
+// by a port, like "[fe80::1]:22"; without a port, brackets are optional. The port is zero
+// when target has none.
+func splitPort(target string) (host string, port int, err error) {
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		return target[1 : len(target)-1], 0, nil
+	}
+
+	if !strings.HasPrefix(target, "[") && strings.Count(target, ":") != 1 {
+		return target, 0, nil
+	}
+
+	host, p, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err = strconv.Atoi(p)
+	if err != nil {
+		return "", 0, errors.Errorf("invalid port in %s", target)
+	}
+
+	return host, port, nil
+}
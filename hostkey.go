@@ -0,0 +1,148 @@
+package operator
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	callback, err := knownhosts.New(expandPath(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read known hosts file: %s", path)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		return hostKeyError(path, hostname, key, err)
+	}, nil
+}
+
+// hostKeyError describes why the known hosts file at path rejected key.
+func hostKeyError(path string, hostname string, key ssh.PublicKey, err error) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	switch e := err.(type) {
+	case *knownhosts.KeyError:
+		if len(e.Want) == 0 {
+			return errors.Errorf("host key verification failed: %s is unknown in %s (%s %s)", hostname, path, key.Type(), fingerprint)
+		}
+		return errors.Errorf("host key verification failed: %s presented %s %s, which does not match %s:%d", hostname, key.Type(), fingerprint, e.Want[0].Filename, e.Want[0].Line)
+	case *knownhosts.RevokedError:
+		return errors.Errorf("host key verification failed: %s presented revoked key %s %s (%s:%d)", hostname, key.Type(), fingerprint, e.Revoked.Filename, e.Revoked.Line)
+	}
+
+	return errors.Wrapf(err, "host key verification failed: %s presented %s %s", hostname, key.Type(), fingerprint)
+}
+
+// WithExpectedFingerprint only accepts a host key with the given SHA-256 fingerprint, in the
+// "SHA256:..." format printed by ssh-keygen -l, e.g. as reported by a cloud provider for a
+// just created machine. It takes precedence over KnownHostsFile.
+func WithExpectedFingerprint(fingerprint string) Option {
+	return func(o *Options) {
+		o.ExpectedFingerprint = fingerprint
+	}
+}
+
+func fingerprintCallback(fingerprint string) (ssh.HostKeyCallback, error) {
+	// ssh-keygen omits the base64 padding, but accept it when present
+	encoded := strings.TrimRight(strings.TrimPrefix(fingerprint, "SHA256:"), "=")
+	hash, err := base64.RawStdEncoding.DecodeString(encoded)
+	if !strings.HasPrefix(fingerprint, "SHA256:") || err != nil || len(hash) != sha256.Size {
+		return nil, errors.Errorf("invalid SHA-256 host key fingerprint: %s", fingerprint)
+	}
+	expected := "SHA256:" + encoded
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprint := ssh.FingerprintSHA256(key); fingerprint != expected {
+			return errors.Errorf("host key verification failed: %s presented %s %s, which does not match %s", hostname, key.Type(), fingerprint, expected)
+		}
+		return nil
+	}, nil
+}
+
+// WithOnHostKey calls fn with the host key of every host connected to, once it has been
+// verified, e.g. to record the fingerprints of a fleet for an audit. It is independent of the
+// verification, which still follows HostKeyCallback or KnownHostsFile.
+func WithOnHostKey(fn func(hostname string, remote net.Addr, key ssh.PublicKey)) Option {
+	return func(o *Options) {
+		o.OnHostKey = fn
+	}
+}
+
+// observedCallback calls fn after callback accepted a host key.
+func observedCallback(callback ssh.HostKeyCallback, fn func(hostname string, remote net.Addr, key ssh.PublicKey)) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := callback(hostname, remote, key); err != nil {
+			return err
+		}
+		fn(hostname, remote, key)
+		return nil
+	}
+}
+
+// WithTOFU trusts the host key of a host on first use, like ssh with
+// StrictHostKeyChecking=accept-new: the key of a host missing from the known_hosts file at
+// path is added to it, and later connections fail when the host presents another key.
+// The file is created when it does not exist.
+func WithTOFU(knownHostsPath string) Option {
+	return func(o *Options) {
+		o.KnownHostsFile = knownHostsPath
+		o.TrustOnFirstUse = true
+	}
+}
+
+// knownHostsMu serializes the additions to known hosts files, so concurrent connections to
+// a new host record it only once.
+var knownHostsMu sync.Mutex
+
+func tofuCallback(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		file := expandPath(path)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return errors.Wrapf(err, "unable to create known hosts file: %s", path)
+		}
+		f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "unable to create known hosts file: %s", path)
+		}
+		defer f.Close()
+
+		// read again on every connection, so keys added since are verified too
+		callback, err := knownhosts.New(file)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read known hosts file: %s", path)
+		}
+
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		// only a host without any known key is trusted, a changed key is rejected
+		if keyErr, ok := err.(*knownhosts.KeyError); !ok || len(keyErr.Want) > 0 {
+			return hostKeyError(path, hostname, key, err)
+		}
+
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			return errors.Wrapf(err, "unable to add %s to known hosts file: %s", hostname, path)
+		}
+		warnf("permanently added %s (%s %s) to %s", hostname, key.Type(), ssh.FingerprintSHA256(key), path)
+
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+)
+
+// Shell starts an interactive login shell on a pseudo-terminal, like ssh without a command,
+// and copies stdin to it and its output to stdout until the shell exits or stdin is closed.
+// Since a terminal has a single output stream, stderr only receives what the server sends
+// outside of it. When stdout is a terminal, its size is used for the pseudo-terminal; put it
+// into raw mode with terminal.MakeRaw to pass keys like Ctrl-C to the remote shell.
+//
+// The Env, WorkingDir and Shell options do not apply to the interactive shell. A stdin that
+// never returns from Read keeps a goroutine blocked in it after the shell exited.
+func (s SSHOperator) Shell(stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	sess, release, err := s.newSession()
+	if err != nil {
+		return err
+	}
+
+	defer release()
+	defer sess.Close()
+
+	if err := s.requestAgentForwarding(sess); err != nil {
+		return err
+	}
+
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "xterm"
+	}
+	width, height := 80, 24
+	if f, ok := stdout.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		if w, h, err := terminal.GetSize(int(f.Fd())); err == nil {
+			width, height = w, h
+		}
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := sess.RequestPty(term, height, width, modes); err != nil {
+		return errors.Wrap(err, "unable to allocate a pseudo-terminal")
+	}
+
+	// a pipe rather than sess.Stdin, which would make Wait block until stdin is closed
+	sessStdin, err := sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	sess.Stdout = writerOrDiscard(stdout)
+	sess.Stderr = writerOrDiscard(stderr)
+
+	if err := sess.Shell(); err != nil {
+		return errors.Wrap(err, "unable to start shell")
+	}
+
+	go func() {
+		if stdin != nil {
+			io.Copy(sessStdin, stdin)
+		}
+		sessStdin.Close()
+	}()
+
+	exited := make(chan struct{})
+	stop := closeOnDone(s.ctx, s.killer(sess, exited))
+	err = sess.Wait()
+	close(exited)
+	stop()
+
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return errors.Wrap(s.ctx.Err(), "shell interrupted")
+		}
+		if lost := s.keepalive.error(); lost != nil {
+			return lost
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			commandErr := &CommandError{Command: "shell", ExitCode: exitErr.ExitStatus(), Err: err}
+			if exitErr.Signal() != "" {
+				commandErr.ExitCode = -1
+				commandErr.Signal = exitErr.Signal()
+			}
+			return commandErr
+		}
+		return err
+	}
+
+	return nil
+}
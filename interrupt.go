@@ -0,0 +1,21 @@
+package operator
+
+import (
+	"time"
+)
+
+// DefaultInterruptGracePeriod is the grace period used by WithGracefulInterrupt when none is given.
+const DefaultInterruptGracePeriod = 5 * time.Second
+
+// WithGracefulInterrupt interrupts remote commands like pressing Ctrl-C in ssh when the
+// context of the operator is done: the command is sent SIGINT, then SIGTERM when it has not
+// exited within grace, and it is killed when it still runs after another grace period.
+// Without it, the command is killed right away. A zero grace selects DefaultInterruptGracePeriod.
+func WithGracefulInterrupt(grace time.Duration) Option {
+	return func(o *Options) {
+		if grace <= 0 {
+			grace = DefaultInterruptGracePeriod
+		}
+		o.InterruptGracePeriod = grace
+	}
+}
@@ -0,0 +1,115 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultKeepaliveInterval is the keepalive interval used by WithKeepalive when none is given.
+	DefaultKeepaliveInterval = 30 * time.Second
+	// DefaultKeepaliveMaxFailures is the number of failed keepalives used by WithKeepalive when none is given.
+	DefaultKeepaliveMaxFailures = 3
+)
+
+// WithKeepalive sends a keepalive request to the server every interval, and closes the
+// connection when maxFailures keepalives in a row go unanswered, like the ServerAliveInterval
+// and ServerAliveCountMax settings of OpenSSH. A running command then fails promptly instead
+// of hanging on a dead connection. Zero values select DefaultKeepaliveInterval and
+// DefaultKeepaliveMaxFailures.
+func WithKeepalive(interval time.Duration, maxFailures int) Option {
+	return func(o *Options) {
+		if interval <= 0 {
+			interval = DefaultKeepaliveInterval
+		}
+		if maxFailures <= 0 {
+			maxFailures = DefaultKeepaliveMaxFailures
+		}
+		o.KeepaliveInterval = interval
+		o.KeepaliveMaxFailures = maxFailures
+	}
+}
+
+// keepalive holds the reason the connection was closed by the keepalive loop, if it was.
+type keepalive struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (k *keepalive) fail(err error) {
+	k.mu.Lock()
+	k.err = err
+	k.mu.Unlock()
+}
+
+func (k *keepalive) error() error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+func (s SSHOperator) startKeepalive() {
+	interval := s.options.KeepaliveInterval
+	if interval <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.conn.Wait()
+		close(done)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		failures := 0
+		for {
+			select {
+			case <-done:
+				return
+			case <-s.closed.done:
+				return
+			case <-ticker.C:
+			}
+
+			err := s.sendKeepalive(interval)
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			if failures >= s.options.KeepaliveMaxFailures {
+				s.keepalive.fail(errors.Wrapf(err, "connection to %s lost after %d failed keepalives", s.conn.RemoteAddr(), failures))
+				if s.closed.borrowed {
+					s.conn.Close()
+				}
+				s.Close()
+				return
+			}
+		}
+	}()
+}
+
+// sendKeepalive sends a keepalive request and waits at most timeout for the reply.
+// Any reply counts, servers that do not know the request answer with a failure.
+func (s SSHOperator) sendKeepalive(timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := s.conn.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return errors.Errorf("no reply within %s", timeout)
+	}
+}
@@ -1,60 +1,509 @@
 package operator
 
 import (
-	goexecute "github.com/alexellis/go-execute/pkg/v1"
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
 	"io"
 	"os"
-	"strconv"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
 )
 
+// localHost identifies the local machine in the reports to the Logger.
+const localHost = "localhost"
+
+// LocalOperator runs commands and file operations on the local machine, e.g. to provision
+// it with the same callback as remote hosts. It is safe for concurrent use.
 type LocalOperator struct {
+	ctx     context.Context
+	options *Options
+}
+
+func NewLocalOperator(opts ...Option) *LocalOperator {
+	return NewLocalOperatorContext(context.Background(), opts...)
+}
+
+// NewLocalOperatorContext creates a LocalOperator whose commands are killed when ctx is done.
+func NewLocalOperatorContext(ctx context.Context, opts ...Option) *LocalOperator {
+	return &LocalOperator{ctx: ctx, options: newOptions(opts)}
+}
+
+func (e LocalOperator) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
-func NewLocalOperator() *LocalOperator {
-	return &LocalOperator{}
+func (e LocalOperator) opts() *Options {
+	if e.options == nil {
+		return &Options{}
+	}
+	return e.options
 }
 
 func (e LocalOperator) Execute(command string) (CommandRes, error) {
-	task := goexecute.ExecTask{
-		Command:     command,
-		Shell:       true,
-		StreamStdio: true,
+	return e.ExecuteWithStdin(command, nil)
+}
+
+func (e LocalOperator) ExecuteWithStdin(command string, stdin io.Reader) (CommandRes, error) {
+	return e.opts().logCommand(e.context(), localHost, command, func() (CommandRes, error) {
+		return e.executeWithStdin(command, stdin)
+	})
+}
+
+func (e LocalOperator) executeWithStdin(command string, stdin io.Reader) (CommandRes, error) {
+	stdout := outputBuffer{limit: e.opts().MaxOutputBytes}
+	stderr := outputBuffer{limit: e.opts().MaxOutputBytes}
+
+	res, err := e.execute(command, stdin, io.MultiWriter(os.Stdout, &stdout), io.MultiWriter(os.Stderr, &stderr))
+
+	res.StdErr = stderr.Bytes()
+	res.StdOut = stdout.Bytes()
+	res.Truncated = stdout.truncated || stderr.truncated
+
+	return res, withStderr(err, command, res.StdErr)
+}
+
+func (e LocalOperator) ExecuteAll(commands []string, stopOnError bool) ([]CommandRes, error) {
+	return executeAll(e.Execute, commands, stopOnError)
+}
+
+// ExecuteTimeout kills the shell running command when the timeout elapses. Processes started
+// by the shell are not killed, and ExecuteTimeout waits for those still writing to its output.
+func (e LocalOperator) ExecuteTimeout(command string, timeout time.Duration) (CommandRes, error) {
+	return e.opts().logCommand(e.context(), localHost, command, func() (CommandRes, error) {
+		return executeTimeout(e.context(), command, timeout, func(ctx context.Context) (CommandRes, error) {
+			operator := e
+			operator.ctx = ctx
+			return operator.executeWithStdin(command, nil)
+		})
+	})
+}
+
+func (e LocalOperator) ExecuteScript(script string) (CommandRes, error) {
+	return e.ExecuteScriptWithOptions(script, ScriptOptions{})
+}
+
+func (e LocalOperator) ExecuteScriptWithOptions(script string, opts ScriptOptions) (CommandRes, error) {
+	return executeScript(e, e.opts().tempDir(os.TempDir()), script, opts)
+}
+
+func (e LocalOperator) ExecuteSudo(command string, password string) (CommandRes, error) {
+	return e.opts().logCommand(e.context(), localHost, command, func() (CommandRes, error) {
+		return e.elevator().run(command, password)
+	})
+}
+
+// elevator runs commands as root without a terminal, so doas and su only work without a password.
+func (e LocalOperator) elevator() elevator {
+	return elevator{elevation: e.opts().Elevation, execute: e.execute, limit: e.opts().MaxOutputBytes}
+}
+
+func (e LocalOperator) ExecuteStream(command string, stdout io.Writer, stderr io.Writer) (CommandRes, error) {
+	return e.opts().logCommand(e.context(), localHost, command, func() (CommandRes, error) {
+		return e.execute(command, nil, stdout, stderr)
+	})
+}
+
+func (e LocalOperator) ExecuteCombined(command string) ([]byte, int, error) {
+	return executeCombined(e.ExecuteStream, command, e.opts().MaxOutputBytes)
+}
+
+func (e LocalOperator) ExecuteString(command string) (string, error) {
+	return trimmedOutput(e.Execute(command))
+}
+
+func (e LocalOperator) ExecuteUntil(command string, predicate func(CommandRes) bool, interval time.Duration, deadline time.Time) (CommandRes, error) {
+	return executeUntil(e.context(), command, predicate, interval, deadline, func(ctx context.Context) (CommandRes, error) {
+		operator := e
+		operator.ctx = ctx
+		return operator.Execute(command)
+	})
+}
+
+func (e LocalOperator) execute(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (CommandRes, error) {
+	ctx := e.context()
+
+	name, args, err := e.opts().Shell.localCommand(command)
+	if err != nil {
+		return CommandRes{}, err
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = e.opts().environ()
+	cmd.Dir = e.opts().WorkingDir
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return CommandRes{}, err
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return CommandRes{}, errors.Wrapf(ctx.Err(), "command interrupted: %s", command)
 	}
 
-	res, err := task.Execute()
 	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			res := CommandRes{ExitCode: exitErr.ExitCode()}
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				res.ExitCode = -1
+				res.Signal = signalName(status.Signal())
+			}
+			return res, &CommandError{Command: command, ExitCode: res.ExitCode, Signal: res.Signal, Err: err}
+		}
 		return CommandRes{}, err
 	}
 
-	return CommandRes{
-		StdErr: []byte(res.Stderr),
-		StdOut: []byte(res.Stdout),
-	}, nil
+	return CommandRes{}, nil
+}
+
+func (e LocalOperator) Tail(ctx context.Context, remotePath string, out io.Writer) error {
+	command := tailCommand(remotePath)
+	_, err := e.opts().logCommand(e.context(), localHost, command, func() (CommandRes, error) {
+		return CommandRes{}, tail(e.context(), ctx, func(ctx context.Context) error {
+			operator := e
+			operator.ctx = ctx
+			stderr := outputBuffer{limit: e.opts().MaxOutputBytes}
+			_, err := operator.execute(command, nil, out, &stderr)
+			return withStderr(err, command, stderr.Bytes())
+		})
+	})
+	return err
+}
+
+func (e LocalOperator) Host() string {
+	return localHost
+}
+
+func (e LocalOperator) Ping() error {
+	return e.context().Err()
+}
+
+func (e LocalOperator) Stat(remotePath string) (os.FileInfo, error) {
+	return os.Stat(remotePath)
+}
+
+func (e LocalOperator) Exists(remotePath string) (bool, error) {
+	return exists(e.Stat(remotePath))
+}
+
+func (e LocalOperator) IsDir(remotePath string) (bool, error) {
+	return isDir(e.Stat(remotePath))
+}
+
+func (e LocalOperator) Mkdir(remotePath string, mode string) error {
+	permissions, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+	return localMkdir(remotePath, permissions)
+}
+
+func (e LocalOperator) MkdirAll(remotePath string, mode string) error {
+	permissions, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+	mkdir := func(p string) error {
+		return localMkdir(p, permissions)
+	}
+	return mkdirAll(remotePath, os.Stat, mkdir, filepath.Dir)
+}
+
+// localMkdir creates dir with exactly the given permissions, regardless of the umask,
+// like directories created over SFTP.
+func localMkdir(dir string, mode os.FileMode) error {
+	if err := os.Mkdir(dir, mode); err != nil {
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
+
+func (e LocalOperator) Remove(remotePath string) error {
+	return os.Remove(remotePath)
+}
+
+func (e LocalOperator) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (e LocalOperator) Chmod(remotePath string, mode os.FileMode) error {
+	return os.Chmod(remotePath, mode)
+}
+
+func (e LocalOperator) Chown(remotePath string, owner string, group string) error {
+	return UploadOptions{Owner: owner, Group: group}.chown(e.elevator(), remotePath)
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "ABRT",
+	syscall.SIGALRM: "ALRM",
+	syscall.SIGFPE:  "FPE",
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGILL:  "ILL",
+	syscall.SIGINT:  "INT",
+	syscall.SIGKILL: "KILL",
+	syscall.SIGPIPE: "PIPE",
+	syscall.SIGQUIT: "QUIT",
+	syscall.SIGSEGV: "SEGV",
+	syscall.SIGTERM: "TERM",
+}
+
+// signalName returns the RFC 4254 name of sig, so local and remote results report signals alike.
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return sig.String()
+}
+
+func (e LocalOperator) WriteFile(remotePath string, data []byte, mode string) error {
+	return e.Upload(bytes.NewReader(data), remotePath, mode)
 }
 
 func (e LocalOperator) UploadFile(path string, remotePath string, mode string) error {
-	source, err := os.Open(expandPath(path))
+	return e.UploadFileWithOptions(path, remotePath, UploadOptions{Mode: mode, Atomic: true, MkdirParents: true})
+}
+
+func (e LocalOperator) UploadFileWithOptions(path string, remotePath string, opts UploadOptions) error {
+	source, info, err := openLocal(path)
 	if err != nil {
 		return err
 	}
 	defer source.Close()
 
-	return e.Upload(source, remotePath, mode)
+	permissions, err := opts.fileMode(info)
+	if err != nil {
+		return err
+	}
+	dirMode, err := opts.dirMode()
+	if err != nil {
+		return err
+	}
+
+	err = e.opts().logUpload(e.context(), localHost, path, remotePath, withProgress(opts.counted(source), info.Size(), opts.Progress), func(source io.Reader) error {
+		return e.upload(source, remotePath, permissions, dirMode, opts.MkdirParents, opts.modTime(info), opts.Atomic)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.chown(e.elevator(), remotePath)
 }
 
 func (e LocalOperator) Upload(source io.Reader, remotePath string, mode string) error {
-	permissions, err := strconv.ParseInt(mode, 10, 32)
+	return e.UploadWithOptions(source, remotePath, UploadOptions{Mode: mode, Atomic: true, MkdirParents: true})
+}
+
+func (e LocalOperator) UploadWithOptions(source io.Reader, remotePath string, opts UploadOptions) error {
+	permissions, err := opts.fileMode(nil)
+	if err != nil {
+		return err
+	}
+	dirMode, err := opts.dirMode()
+	if err != nil {
+		return err
+	}
+
+	err = e.opts().logUpload(e.context(), localHost, "", remotePath, withProgress(opts.counted(source), -1, opts.Progress), func(source io.Reader) error {
+		return e.upload(source, remotePath, permissions, dirMode, opts.MkdirParents, time.Time{}, opts.Atomic)
+	})
 	if err != nil {
 		return err
 	}
 
-	destination, err := os.OpenFile(remotePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(permissions))
+	return opts.chown(e.elevator(), remotePath)
+}
+
+func (e LocalOperator) UploadAt(source io.ReaderAt, size int64, remotePath string, opts UploadOptions) error {
+	reader, err := opts.resumeSource(source, size, remotePath)
 	if err != nil {
 		return err
 	}
-	defer destination.Close()
+	if opts.Offset == 0 {
+		// progress is reported by reader, which knows the total size
+		opts.Progress = nil
+		return e.UploadWithOptions(reader, remotePath, opts)
+	}
 
-	_, err = io.Copy(destination, source)
+	permissions, err := opts.fileMode(nil)
+	if err != nil {
+		return err
+	}
+
+	err = e.opts().logUpload(e.context(), localHost, "", remotePath, reader, func(source io.Reader) error {
+		destination, err := os.OpenFile(remotePath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return diskFullError(remotePath, resumeFile(destination, source, remotePath, permissions, opts.Offset))
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.chown(e.elevator(), remotePath)
+}
+
+func (e LocalOperator) UploadN(source io.Reader, remotePath string, mode string) (int64, error) {
+	return uploadN(mode, func(opts UploadOptions) error {
+		return e.UploadWithOptions(source, remotePath, opts)
+	})
+}
+
+func (e LocalOperator) UploadFileN(path string, remotePath string, mode string) (int64, error) {
+	return uploadN(mode, func(opts UploadOptions) error {
+		return e.UploadFileWithOptions(path, remotePath, opts)
+	})
+}
+
+func (e LocalOperator) UploadFileVerified(path string, remotePath string, mode string) error {
+	if err := e.UploadFile(path, remotePath, mode); err != nil {
+		return err
+	}
+	return verifyChecksum(path, remotePath, fileSHA256)
+}
+
+// upload writes source to remotePath. With parents, its missing parent directories are created
+// with dirMode, or 0755 when dirMode is zero.
+func (e LocalOperator) upload(source io.Reader, remotePath string, mode os.FileMode, dirMode os.FileMode, parents bool, modTime time.Time, atomic bool) error {
+	if parents {
+		if dirMode == 0 {
+			dirMode = 0755
+		}
+		if err := os.MkdirAll(filepath.Dir(remotePath), dirMode); err != nil {
+			return err
+		}
+	}
+
+	if !atomic {
+		return diskFullError(remotePath, writeFile(source, remotePath, os.O_TRUNC, mode, modTime))
+	}
+
+	name, err := tempName(filepath.Base(remotePath))
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(filepath.Dir(remotePath), name)
+	err = writeFile(source, tmpPath, os.O_EXCL, mode, modTime)
+	if err == nil {
+		err = os.Rename(tmpPath, remotePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return diskFullError(remotePath, err)
+}
+
+// writeFile creates or opens the file at path with the additional flag, and writes source to it.
+func writeFile(source io.Reader, path string, flag int, mode os.FileMode, modTime time.Time) error {
+	destination, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|flag, mode)
+	if err != nil {
+		return err
+	}
+
+	// an existing file keeps its permissions when opened, and a new one is subject to the
+	// umask, so they are set before any data is written
+	err = destination.Chmod(mode)
+	if err == nil {
+		_, err = io.Copy(destination, source)
+	}
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(path, modTime, modTime)
+	}
+
+	return err
+}
+
+func (e LocalOperator) UploadDir(localDir string, remoteDir string, mode string) error {
+	mkdir := func(remotePath string) error {
+		return os.MkdirAll(remotePath, 0755)
+	}
+	return uploadDir(localDir, remoteDir, mkdir, func(path string, remotePath string) error {
+		return e.UploadFile(path, remotePath, mode)
+	})
+}
+
+func (e LocalOperator) Sync(localDir string, remoteDir string, opts SyncOptions) ([]string, error) {
+	return syncDir(e, localDir, remoteDir, opts, listLocal, fileSHA256)
+}
+
+// listLocal returns everything below dir by slash-separated relative path, without following
+// symbolic links. A missing dir is empty.
+func listLocal(dir string) (map[string]os.FileInfo, error) {
+	listed := map[string]os.FileInfo{}
+
+	err := filepath.Walk(dir, func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			if current == dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, current)
+		if err != nil || rel == "." {
+			return err
+		}
+		listed[filepath.ToSlash(rel)] = info
+		return nil
+	})
+
+	return listed, err
+}
+
+func (e LocalOperator) UploadGlob(pattern string, remoteDir string, mode string) ([]string, error) {
+	return uploadGlob(pattern, remoteDir, func(path string, remotePath string) error {
+		if err := os.MkdirAll(remoteDir, 0755); err != nil {
+			return err
+		}
+		return e.UploadFile(path, remotePath, mode)
+	})
+}
+
+func (e LocalOperator) Download(remotePath string, dst io.Writer) error {
+	return e.DownloadWithOptions(remotePath, dst, DownloadOptions{})
+}
+
+func (e LocalOperator) DownloadWithOptions(remotePath string, dst io.Writer, opts DownloadOptions) error {
+	source, err := os.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.Errorf("unable to download %s: is a directory", remotePath)
+	}
+
+	_, err = io.Copy(dst, withProgress(source, info.Size(), opts.Progress))
 
 	return err
 }
+
+func (e LocalOperator) DownloadFile(remotePath string, localPath string) error {
+	return e.DownloadFileWithOptions(remotePath, localPath, DownloadOptions{})
+}
+
+func (e LocalOperator) ReadFile(remotePath string) ([]byte, error) {
+	return readFile(e, remotePath, e.opts().ReadFileLimit)
+}
+
+func (e LocalOperator) DownloadFileWithOptions(remotePath string, localPath string, opts DownloadOptions) error {
+	return downloadFile(e, remotePath, localPath, opts)
+}
@@ -0,0 +1,81 @@
+package operator
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Logger receives the commands executed and the files uploaded by an operator, e.g. to
+// keep an audit log of a provisioning run. For uploads from an io.Reader, path is empty.
+// An operator used from several goroutines calls its Logger concurrently.
+type Logger interface {
+	OnCommand(host string, command string)
+	OnResult(host string, res CommandRes, err error)
+	OnUpload(path string, remotePath string, bytes int64)
+}
+
+// WithLogger reports every executed command and uploaded file to l.
+func WithLogger(l Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+// logCommand runs execute, reporting command and its result to the configured Logger and Tracer.
+func (o *Options) logCommand(ctx context.Context, host string, command string, execute func() (CommandRes, error)) (CommandRes, error) {
+	span := &Span{Name: "Execute", Host: host, Command: command}
+	end := o.trace(ctx, span)
+
+	if o.Logger != nil {
+		o.Logger.OnCommand(host, command)
+	}
+	start := time.Now()
+	res, err := execute()
+	res.Duration = time.Since(start)
+	if o.Logger != nil {
+		o.Logger.OnResult(host, res, err)
+	}
+
+	span.ExitCode = res.ExitCode
+	end(err)
+
+	return res, err
+}
+
+// logUpload runs upload with source, reporting the transfer to the configured Logger when it
+// succeeds, and to the configured Tracer.
+func (o *Options) logUpload(ctx context.Context, host string, path string, remotePath string, source io.Reader, upload func(io.Reader) error) error {
+	if o.Logger == nil && o.Tracer == nil {
+		return upload(source)
+	}
+
+	span := &Span{Name: "Upload", Host: host, Path: path, RemotePath: remotePath}
+	end := o.trace(ctx, span)
+
+	counter := &countingReader{r: source}
+	err := upload(counter)
+
+	span.Bytes = counter.n
+	end(err)
+
+	if err != nil {
+		return err
+	}
+	if o.Logger != nil {
+		o.Logger.OnUpload(path, remotePath, counter.n)
+	}
+
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
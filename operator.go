@@ -2,40 +2,246 @@ package operator
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
-	"golang.org/x/crypto/ssh/terminal"
 	"io"
 	"io/ioutil"
-	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 type CommandRes struct {
 	StdOut []byte
 	StdErr []byte
+	// ExitCode is the exit status of the command, or -1 when it was terminated by a signal or
+	// the server did not report an exit status.
+	ExitCode int
+	// Signal is the name of the signal that terminated the command, as defined by
+	// RFC 4254 without the "SIG" prefix (e.g. "KILL"). It is empty when the command exited normally.
+	Signal string
+	// Truncated is set when StdOut or StdErr was cut off at the limit set with WithMaxOutputBytes.
+	Truncated bool
+	// Duration is the time the command took, from starting it until it exited.
+	Duration time.Duration
 }
 
 type CommandOperator interface {
 	Execute(command string) (CommandRes, error)
+	// ExecuteWithStdin runs command like Execute, feeding stdin to its standard input.
+	// A nil stdin is treated as empty input.
+	ExecuteWithStdin(command string, stdin io.Reader) (CommandRes, error)
+	// ExecuteSudo runs command as root using sudo -S, feeding password to sudo on its standard input,
+	// or with the tool set with WithElevation. The password never reaches the command or its
+	// output. ErrIncorrectSudoPassword is returned when the password is rejected.
+	ExecuteSudo(command string, password string) (CommandRes, error)
+	// ExecuteStream runs command and copies its output to stdout and stderr as it is produced.
+	// The output is not buffered, so the StdOut and StdErr fields of the returned CommandRes are empty.
+	ExecuteStream(command string, stdout io.Writer, stderr io.Writer) (CommandRes, error)
+	// ExecuteAll runs commands in order and returns the result of every command that ran, so
+	// the exit code and output of a failed step can be inspected. With stopOnError, it stops at
+	// the first failure and returns its error. Otherwise all commands run, and when any of them
+	// failed the error is a *BatchError.
+	ExecuteAll(commands []string, stopOnError bool) ([]CommandRes, error)
+	// ExecuteTimeout runs command like Execute, but kills it when it has not finished within
+	// timeout. The error is then a *TimeoutError, and the returned CommandRes holds the output
+	// produced until then. Other commands on the same connection are not affected.
+	ExecuteTimeout(command string, timeout time.Duration) (CommandRes, error)
+	// ExecuteScript writes script to a temporary file, runs it with bash after
+	// DefaultScriptPreamble, and removes the file again. When the script fails, the error is a
+	// *CommandError with its exit code.
+	ExecuteScript(script string) (CommandRes, error)
+	ExecuteScriptWithOptions(script string, opts ScriptOptions) (CommandRes, error)
+	// Tail writes the last lines of remotePath and everything appended to it afterwards to out,
+	// like tail -f, until ctx is done. The tail command is then stopped and nil is returned.
+	Tail(ctx context.Context, remotePath string, out io.Writer) error
+	// ExecuteCombined runs command and returns its standard output and standard error merged
+	// into one stream in the order they were written, like 2>&1, together with the exit code.
+	ExecuteCombined(command string) ([]byte, int, error)
+	// ExecuteString runs command like Execute and returns its standard output without leading
+	// and trailing white space, e.g. for the output of hostname. A command exiting with a
+	// non-zero status still fails with a *CommandError.
+	ExecuteString(command string) (string, error)
+	// ExecuteUntil runs command like Execute until predicate accepts its result, e.g. to wait
+	// for a service to come up. It waits interval (a second when zero) after the first attempt,
+	// and twice as long after every further one. An attempt still running at deadline is killed.
+	// When predicate has not accepted a result before the next attempt would start after
+	// deadline, the error is a *DeadlineError. Errors other than a *CommandError, like a lost
+	// connection, are returned right away.
+	ExecuteUntil(command string, predicate func(CommandRes) bool, interval time.Duration, deadline time.Time) (CommandRes, error)
 	Upload(src io.Reader, remotePath string, mode string) error
 	UploadFile(path string, remotePath string, mode string) error
+	// UploadN and UploadFileN upload like Upload and UploadFile, and return the number of bytes
+	// uploaded. When the upload fails, it is the number of bytes read before the failure.
+	UploadN(src io.Reader, remotePath string, mode string) (int64, error)
+	UploadFileN(path string, remotePath string, mode string) (int64, error)
+	UploadWithOptions(src io.Reader, remotePath string, opts UploadOptions) error
+	// WriteFile writes data to remotePath like Upload, so the file appears atomically with the
+	// permissions in mode.
+	WriteFile(remotePath string, data []byte, mode string) error
+	UploadFileWithOptions(path string, remotePath string, opts UploadOptions) error
+	// UploadAt uploads size bytes of src to remotePath like UploadWithOptions. When opts.Offset
+	// is set, an interrupted upload is resumed: only the bytes from Offset on are sent, and
+	// written to the existing remote file at the same position.
+	UploadAt(src io.ReaderAt, size int64, remotePath string, opts UploadOptions) error
+	// UploadFileVerified uploads the file at path like UploadFile, and then verifies that the
+	// SHA-256 checksum of the remote file matches the one of the local file.
+	UploadFileVerified(path string, remotePath string, mode string) error
+	// UploadDir recursively uploads the contents of localDir to remoteDir, creating remote
+	// directories as needed and giving every uploaded file the permissions in mode.
+	// Symbolic links and other non-regular files are not followed; they are skipped with a warning.
+	UploadDir(localDir string, remoteDir string, mode string) error
+	// UploadGlob uploads every file matching the filepath.Glob pattern into remoteDir, giving
+	// them the permissions in mode, and returns their remote paths. A pattern matching no
+	// files is not an error, the returned slice is empty then.
+	UploadGlob(pattern string, remoteDir string, mode string) ([]string, error)
+	// Sync uploads the files below localDir which are missing below remoteDir, or differ in
+	// size or modification time, like rsync, and returns the remote paths of the uploaded (and
+	// with opts.Delete, removed) files. Uploaded files keep the modification time of the local
+	// file, so an unchanged file is skipped by the next Sync.
+	Sync(localDir string, remoteDir string, opts SyncOptions) ([]string, error)
+	Download(remotePath string, dst io.Writer) error
+	DownloadFile(remotePath string, localPath string) error
+	DownloadWithOptions(remotePath string, dst io.Writer, opts DownloadOptions) error
+	DownloadFileWithOptions(remotePath string, localPath string, opts DownloadOptions) error
+	// ReadFile returns the contents of remotePath. Files larger than the limit set with
+	// WithReadFileLimit are refused.
+	ReadFile(remotePath string) ([]byte, error)
+	// Stat returns the file info of remotePath, following symbolic links. When the path does
+	// not exist, the error satisfies os.IsNotExist.
+	Stat(remotePath string) (os.FileInfo, error)
+	// Exists reports whether remotePath exists. A missing path is not an error; only failures
+	// like a denied permission or a broken connection are.
+	Exists(remotePath string) (bool, error)
+	// IsDir reports whether remotePath is a directory. A missing path is reported as false.
+	IsDir(remotePath string) (bool, error)
+	// Mkdir creates the directory remotePath with the permissions in mode. It fails when
+	// remotePath already exists, with an error satisfying os.IsExist.
+	Mkdir(remotePath string, mode string) error
+	// MkdirAll creates the directory remotePath and any missing parents, giving every created
+	// directory the permissions in mode. It does nothing when remotePath already is a directory.
+	MkdirAll(remotePath string, mode string) error
+	// Remove removes the file or empty directory remotePath. When it does not exist, the
+	// error satisfies os.IsNotExist.
+	Remove(remotePath string) error
+	// Rename renames oldPath to newPath, replacing newPath if it is an existing file.
+	Rename(oldPath string, newPath string) error
+	// Chmod changes the permissions of remotePath to mode. When it does not exist, the error
+	// satisfies os.IsNotExist.
+	Chmod(remotePath string, mode os.FileMode) error
+	// Chown changes the owner and group of remotePath with chown, which accepts names as well
+	// as numeric IDs. An empty owner or group is left alone. Giving a file away to another
+	// user requires root privileges.
+	Chown(remotePath string, owner string, group string) error
+	// Host identifies the machine the operator runs commands on, e.g. for logging in a callback
+	// shared by several hosts. It is the host an SSHOperator was dialed as, and "localhost"
+	// for a LocalOperator.
+	Host() string
+	// Ping checks that the operator is usable without running a command. For an SSHOperator,
+	// it opens and closes a session, which fails when the connection has been lost.
+	Ping() error
 }
 
 type Callback func(CommandOperator) error
 
-func ExecuteLocal(callback Callback) error {
-	return callback(NewLocalOperator())
+func ExecuteLocal(callback Callback, opts ...Option) error {
+	return ExecuteLocalContext(context.Background(), callback, opts...)
 }
 
-func ExecuteRemoteWithPassword(host string, port int, user string, password string, callback Callback) error {
-	return executeRemote(host, port, user, ssh.Password(password), callback)
+func ExecuteLocalContext(ctx context.Context, callback Callback, opts ...Option) error {
+	return callback(NewLocalOperatorContext(ctx, opts...))
 }
 
-func ExecuteRemoteWithPrivateKey(host string, port int, user string, privateKey string, callback Callback) error {
+func ExecuteRemoteWithPassword(host string, port int, user string, password string, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithPasswordContext(context.Background(), host, port, user, password, callback, opts...)
+}
+
+func ExecuteRemoteWithPasswordContext(ctx context.Context, host string, port int, user string, password string, callback Callback, opts ...Option) error {
+	return executeRemote(ctx, host, port, user, ssh.Password(password), callback, opts...)
+}
+
+// ExecuteRemoteWithAuthMethods tries the given authentication methods in order until the
+// server accepts one, e.g. the SSH agent first, then a private key, then a password prompt.
+// Each kind of method is only tried once, so pass several keys in a single ssh.PublicKeys.
+func ExecuteRemoteWithAuthMethods(host string, port int, user string, methods []ssh.AuthMethod, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithAuthMethodsContext(context.Background(), host, port, user, methods, callback, opts...)
+}
+
+func ExecuteRemoteWithAuthMethodsContext(ctx context.Context, host string, port int, user string, methods []ssh.AuthMethod, callback Callback, opts ...Option) error {
+	options := newOptions(opts)
+	options.User = user
+	options.Auth = methods
+
+	return ExecuteRemoteWithOptionsContext(ctx, host, port, *options, callback)
+}
+
+// ExecuteRemoteTarget connects to a [user@]host[:port] target, as parsed by ParseTarget, and
+// tries the given authentication methods like ExecuteRemoteWithAuthMethods.
+func ExecuteRemoteTarget(target string, methods []ssh.AuthMethod, callback Callback, opts ...Option) error {
+	return ExecuteRemoteTargetContext(context.Background(), target, methods, callback, opts...)
+}
+
+func ExecuteRemoteTargetContext(ctx context.Context, target string, methods []ssh.AuthMethod, callback Callback, opts ...Option) error {
+	user, host, port, err := ParseTarget(target)
+	if err != nil {
+		return err
+	}
+
+	return ExecuteRemoteWithAuthMethodsContext(ctx, host, port, user, methods, callback, opts...)
+}
+
+// ExecuteRemoteNoAuth connects without credentials, for network gear and appliances which
+// grant access with the "none" authentication method.
+func ExecuteRemoteNoAuth(host string, port int, user string, callback Callback, opts ...Option) error {
+	return ExecuteRemoteNoAuthContext(context.Background(), host, port, user, callback, opts...)
+}
+
+func ExecuteRemoteNoAuthContext(ctx context.Context, host string, port int, user string, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithAuthMethodsContext(ctx, host, port, user, nil, callback, opts...)
+}
+
+// ExecuteRemoteWithKeyboardInteractive authenticates with the keyboard-interactive method,
+// answering the questions of the server (e.g. a password followed by a one-time code) with challenge.
+// When challenge is nil, the questions are asked on the terminal using TerminalChallenge, unless
+// interactive prompts are disabled.
+func ExecuteRemoteWithKeyboardInteractive(host string, port int, user string, challenge ssh.KeyboardInteractiveChallenge, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithKeyboardInteractiveContext(context.Background(), host, port, user, challenge, callback, opts...)
+}
+
+func ExecuteRemoteWithKeyboardInteractiveContext(ctx context.Context, host string, port int, user string, challenge ssh.KeyboardInteractiveChallenge, callback Callback, opts ...Option) error {
+	if challenge == nil {
+		if newOptions(opts).DisableInteractivePrompts {
+			return errors.New("keyboard-interactive authentication needs a challenge when interactive prompts are disabled")
+		}
+		challenge = TerminalChallenge
+	}
+	return executeRemote(ctx, host, port, user, ssh.KeyboardInteractive(challenge), callback, opts...)
+}
+
+// ExecuteRemoteWithPrivateKey authenticates with the private key at privateKey. The passphrase
+// of a passphrase-protected key is taken from WithPassphrase or WithPassphraseFunc, then from
+// the variable set with WithPassphraseEnv. Otherwise the key is used through the SSH agent
+// when it holds it, and as a last resort the passphrase is prompted for on the terminal.
+// Percent tokens like %h and %r in privateKey are expanded as in an IdentityFile of the ssh
+// config, e.g. "~/.ssh/id_%r@%h".
+func ExecuteRemoteWithPrivateKey(host string, port int, user string, privateKey string, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithPrivateKeyContext(context.Background(), host, port, user, privateKey, callback, opts...)
+}
+
+func ExecuteRemoteWithPrivateKeyContext(ctx context.Context, host string, port int, user string, privateKey string, callback Callback, opts ...Option) error {
+	options := newOptions(opts)
+
+	path, err := expandTokens(privateKey, host, HostSpec{Host: host, Port: port, User: user})
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse private key: %s", privateKey)
+	}
+	privateKey = path
+
 	buffer, err := ioutil.ReadFile(expandPath(privateKey))
 	if err != nil {
 		return errors.Wrapf(err, "unable to parse private key: %s", privateKey)
@@ -49,61 +255,90 @@ func ExecuteRemoteWithPrivateKey(host string, port int, user string, privateKey
 			return errors.Wrapf(err, "unable to parse private key: %s", privateKey)
 		}
 
-		sshAgent, closeAgent := privateKeyUsingSSHAgent(privateKey + ".pub")
-		defer closeAgent()
+		passphrase, supplied, err := options.suppliedPassphrase(privateKey)
+		if err != nil {
+			return err
+		}
+
+		if !supplied && !options.DisableAgent {
+			sshAgent, closeAgent := privateKeyUsingSSHAgent(privateKey + ".pub")
+			defer closeAgent()
 
-		if sshAgent != nil {
 			method = sshAgent
-		} else {
-			fmt.Printf("Enter passphrase for '%s': ", privateKey)
-			STDIN := int(os.Stdin.Fd())
-			bytePassword, _ := terminal.ReadPassword(STDIN)
-			fmt.Println()
+		}
 
-			key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, bytePassword)
+		if !supplied && method == nil {
+			if passphrase, err = options.promptPassphrase(privateKey); err != nil {
+				return err
+			}
+		}
+
+		if method == nil {
+			key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, passphrase)
 			if err != nil {
 				return errors.Wrapf(err, "parse private key with passphrase failed: %s", privateKey)
 			}
-			method = ssh.PublicKeys(key)
 		}
-	} else {
-		method = ssh.PublicKeys(key)
 	}
 
-	return executeRemote(host, port, user, method, callback)
+	if method == nil {
+		signer, err := certificateSigner(key, privateKey, user, options.CertificateFile)
+		if err != nil {
+			return err
+		}
+		method = ssh.PublicKeys(signer)
+	}
+
+	return executeRemote(ctx, host, port, user, method, callback, opts...)
 }
 
-func ExecuteRemote(host string, port int, user string, callback Callback) error {
-	sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+// ExecuteRemoteWithPrivateKeyBytes authenticates with a PEM encoded private key held in memory,
+// e.g. read from an environment variable or a secrets manager, so it never has to be written
+// to disk. passphrase decrypts a passphrase-protected key and may be nil otherwise.
+func ExecuteRemoteWithPrivateKeyBytes(host string, port int, user string, keyPEM []byte, passphrase []byte, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithPrivateKeyBytesContext(context.Background(), host, port, user, keyPEM, passphrase, callback, opts...)
+}
+
+func ExecuteRemoteWithPrivateKeyBytesContext(ctx context.Context, host string, port int, user string, keyPEM []byte, passphrase []byte, callback Callback, opts ...Option) error {
+	var key ssh.Signer
+	var err error
 
+	if len(passphrase) == 0 {
+		key, err = ssh.ParsePrivateKey(keyPEM)
+	} else {
+		key, err = ssh.ParsePrivateKeyWithPassphrase(keyPEM, passphrase)
+	}
 	if err != nil {
-		return errors.Wrapf(err, "unable to reach SSH Agent")
+		return errors.Wrap(err, "unable to parse private key")
 	}
 
-	defer sshAgent.Close()
-
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	signer, err := certificateSigner(key, "", user, newOptions(opts).CertificateFile)
+	if err != nil {
+		return err
 	}
 
-	address := fmt.Sprintf("%s:%d", host, port)
-	operator, err := NewSSHOperator(address, config)
+	return executeRemote(ctx, host, port, user, ssh.PublicKeys(signer), callback, opts...)
+}
+
+// ExecuteRemote authenticates with the keys of the SSH agent at SSH_AUTH_SOCK, and fails with
+// ErrNoSSHAgent when it is not set, except on Windows where the OpenSSH agent service is used.
+func ExecuteRemote(host string, port int, user string, callback Callback, opts ...Option) error {
+	return ExecuteRemoteContext(context.Background(), host, port, user, callback, opts...)
+}
 
+func ExecuteRemoteContext(ctx context.Context, host string, port int, user string, callback Callback, opts ...Option) error {
+	sshAgent, err := dialAgent()
 	if err != nil {
-		return errors.Wrapf(err, "unable to connect to %s over ssh", address)
+		return err
 	}
 
-	defer operator.Close()
+	defer sshAgent.Close()
 
-	return callback(operator)
+	return executeRemote(ctx, host, port, user, ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers), callback, opts...)
 }
 
 func privateKeyUsingSSHAgent(publicKeyPath string) (ssh.AuthMethod, func() error) {
-	if sshAgentConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
+	if sshAgentConn, err := dialAgent(); err == nil {
 		sshAgent := agent.NewClient(sshAgentConn)
 
 		keys, _ := sshAgent.List()
@@ -124,26 +359,140 @@ func privateKeyUsingSSHAgent(publicKeyPath string) (ssh.AuthMethod, func() error
 
 		for _, key := range keys {
 			if bytes.Equal(key.Blob, parsedkey) {
-				return ssh.PublicKeysCallback(sshAgent.Signers), sshAgentConn.Close
+				return ssh.PublicKeysCallback(agentSigner(sshAgent, parsedkey)), sshAgentConn.Close
 			}
 		}
 	}
 	return nil, func() error { return nil }
 }
 
-func executeRemote(host string, port int, user string, authMethod ssh.AuthMethod, callback Callback) error {
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			authMethod,
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// agentSigner returns the signers of the agent for the public key only, so the server is not
+// offered every key of the agent, which fails with too many authentication failures.
+func agentSigner(sshAgent agent.ExtendedAgent, publicKey []byte) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		signers, err := sshAgent.Signers()
+		if err != nil {
+			return nil, err
+		}
+		for _, signer := range signers {
+			if bytes.Equal(signer.PublicKey().Marshal(), publicKey) {
+				return []ssh.Signer{signer}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// Dial connects to host and returns an SSHOperator that can be used for any number of
+// commands and uploads. Every call opens a new session on the same underlying connection.
+// The caller is responsible for calling Close on the returned operator when done with it.
+func Dial(host string, port int, user string, auth ssh.AuthMethod, opts ...Option) (*SSHOperator, error) {
+	return DialContext(context.Background(), host, port, user, auth, opts...)
+}
+
+// DialContext is like Dial, but aborts the connection attempt when ctx is done. The context
+// also bounds the lifetime of the returned operator: commands are interrupted once ctx is done.
+func DialContext(ctx context.Context, host string, port int, user string, auth ssh.AuthMethod, opts ...Option) (*SSHOperator, error) {
+	target := HostSpec{Host: host, Port: port, User: user, Auth: []ssh.AuthMethod{auth}}
+	return DialViaContext(ctx, nil, target, opts...)
+}
+
+// DialVia connects to target through a chain of jump hosts, like ssh -J. The first jump host
+// is dialed directly, every following host (and finally the target) is reached by tunneling
+// through the previous one. Each hop authenticates with its own credentials.
+// The caller is responsible for calling Close on the returned operator, which also closes
+// the connections to the jump hosts.
+func DialVia(jumps []HostSpec, target HostSpec, opts ...Option) (*SSHOperator, error) {
+	return DialViaContext(context.Background(), jumps, target, opts...)
+}
+
+func DialViaContext(ctx context.Context, jumps []HostSpec, target HostSpec, opts ...Option) (*SSHOperator, error) {
+	return dialVia(ctx, jumps, target, newOptions(opts))
+}
+
+func dialVia(ctx context.Context, jumps []HostSpec, target HostSpec, options *Options) (*SSHOperator, error) {
+	hops := append(append([]HostSpec{}, jumps...), target)
+	clients := make([]*ssh.Client, 0, len(hops))
+	// overwritten by every hop, so the target's is kept
+	var timing Timing
+
+	for _, hop := range hops {
+		if hop.User == "" {
+			user, err := currentUser()
+			if err != nil {
+				return nil, err
+			}
+			hop.User = user
+		}
+
+		config, err := options.clientConfig(hop.User, hop.Auth)
+		if err != nil {
+			return nil, err
+		}
+		if len(hop.HostKeyAlgorithms) > 0 {
+			config.HostKeyAlgorithms = hop.HostKeyAlgorithms
+		}
+		address := hop.address()
+
+		end := options.trace(ctx, &Span{Name: "Dial", Host: address})
+		client, err := options.retry(ctx, func() (*ssh.Client, error) {
+			return options.legacyFallback(address, config, func(config *ssh.ClientConfig) (*ssh.Client, error) {
+				if len(clients) == 0 {
+					return dialContext(ctx, address, config, options.Dialer, &timing)
+				}
+				return dialThrough(ctx, clients[len(clients)-1], address, config, &timing)
+			})
+		})
+		end(err)
+
+		if err != nil {
+			for i := len(clients) - 1; i >= 0; i-- {
+				clients[i].Close()
+			}
+			return nil, connectError(address, hop.User, err)
+		}
+
+		clients = append(clients, client)
+	}
+
+	operator, err := newSSHOperator(ctx, target.name(), clients[len(clients)-1], clients[:len(clients)-1], options, false)
+	if err != nil {
+		return nil, err
+	}
+	operator.timing = timing
+
+	return operator, nil
+}
+
+// ExecuteRemoteVia connects to target through the given jump hosts and executes the callback.
+func ExecuteRemoteVia(jumps []HostSpec, target HostSpec, callback Callback, opts ...Option) error {
+	return ExecuteRemoteViaContext(context.Background(), jumps, target, callback, opts...)
+}
+
+func ExecuteRemoteViaContext(ctx context.Context, jumps []HostSpec, target HostSpec, callback Callback, opts ...Option) error {
+	operator, err := DialViaContext(ctx, jumps, target, opts...)
+	if err != nil {
+		return err
 	}
-	address := fmt.Sprintf("%s:%d", host, port)
-	operator, err := NewSSHOperator(address, config)
 
+	defer operator.Close()
+
+	return callback(operator)
+}
+
+// ExecuteRemoteWithOptions connects to host using the user, authentication methods, host key
+// verification and connection settings in opts, and executes the callback.
+// See Options.HostKeyCallback for the (insecure) default host key verification.
+func ExecuteRemoteWithOptions(host string, port int, opts Options, callback Callback) error {
+	return ExecuteRemoteWithOptionsContext(context.Background(), host, port, opts, callback)
+}
+
+func ExecuteRemoteWithOptionsContext(ctx context.Context, host string, port int, opts Options, callback Callback) error {
+	target := HostSpec{Host: host, Port: port, User: opts.User, Auth: opts.Auth}
+
+	operator, err := dialVia(ctx, nil, target, &opts)
 	if err != nil {
-		return errors.Wrapf(err, "unable to connect to %s over ssh", address)
+		return err
 	}
 
 	defer operator.Close()
@@ -151,7 +500,109 @@ func executeRemote(host string, port int, user string, authMethod ssh.AuthMethod
 	return callback(operator)
 }
 
+func executeRemote(ctx context.Context, host string, port int, user string, authMethod ssh.AuthMethod, callback Callback, opts ...Option) error {
+	return ExecuteRemoteWithAuthMethodsContext(ctx, host, port, user, []ssh.AuthMethod{authMethod}, callback, opts...)
+}
+
+func warnf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
+
+// executeTimeout calls execute with a context which expires after timeout, and reports the
+// expiry as a TimeoutError. ctx is the context the operator was created with.
+func executeTimeout(ctx context.Context, command string, timeout time.Duration, execute func(context.Context) (CommandRes, error)) (CommandRes, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := execute(timeoutCtx)
+	if err != nil && ctx.Err() == nil && timeoutCtx.Err() == context.DeadlineExceeded {
+		res.ExitCode = -1
+		return res, &TimeoutError{Command: command, Timeout: timeout, Stdout: res.StdOut, Stderr: res.StdErr}
+	}
+	return res, err
+}
+
+// maxUntilInterval is the maximum time ExecuteUntil waits between two attempts.
+const maxUntilInterval = time.Minute
+
+// executeUntil calls execute with a context which expires at deadline until predicate
+// accepts its result. ctx is the context the operator was created with.
+func executeUntil(ctx context.Context, command string, predicate func(CommandRes) bool, interval time.Duration, deadline time.Time, execute func(context.Context) (CommandRes, error)) (CommandRes, error) {
+	deadlineCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	wait := interval
+	if wait <= 0 {
+		wait = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		res, err := execute(deadlineCtx)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+
+		var commandErr *CommandError
+		if err != nil && !errors.As(err, &commandErr) && deadlineCtx.Err() == nil {
+			return res, err
+		}
+		if deadlineCtx.Err() == nil && predicate(res) {
+			return res, nil
+		}
+
+		// an attempt starting at the deadline would be killed right away
+		if time.Until(deadline) <= wait {
+			return res, &DeadlineError{Command: command, Attempts: attempt, Err: err}
+		}
+
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > maxUntilInterval {
+			wait = maxUntilInterval
+		}
+	}
+}
+
+type streamFunc func(command string, stdout io.Writer, stderr io.Writer) (CommandRes, error)
+
+func executeCombined(stream streamFunc, command string, limit int64) ([]byte, int, error) {
+	output := outputBuffer{limit: limit}
+	combined := &syncWriter{w: io.MultiWriter(os.Stdout, &output)}
+
+	res, err := stream(command, combined, combined)
+
+	return output.Bytes(), res.ExitCode, err
+}
+
+// trimmedOutput returns the standard output of res without surrounding white space, along
+// with err, as returned by ExecuteString.
+func trimmedOutput(res CommandRes, err error) (string, error) {
+	return strings.TrimSpace(string(res.StdOut)), err
+}
+
+// syncWriter serializes the writes to w, so stdout and stderr can safely share it.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return ioutil.Discard
+	}
+	return w
+}
+
 func expandPath(path string) string {
 	res, _ := homedir.Expand(path)
 	return res
-}
\ No newline at end of file
+}
@@ -0,0 +1,817 @@
+// Package operatortest provides a MockOperator for testing operator.Callback functions
+// without a real machine or SSH server, and an in-process SSH server for integration tests.
+package operatortest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jsiebens/operator"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Call records a single method call on a MockOperator.
+type Call struct {
+	// Method is the name of the called method, e.g. "Execute" or "UploadFile".
+	Method string
+	// Command is the executed command.
+	Command string
+	// Stdin holds the data read from the standard input passed to ExecuteWithStdin.
+	Stdin []byte
+	// Path is the local path of UploadFile, UploadDir, Sync and DownloadFile, or the pattern of UploadGlob.
+	Path string
+	// RemotePath is the remote path of the file and directory methods.
+	RemotePath string
+	// Mode is the permission string of the upload methods.
+	Mode string
+	// Options holds the UploadOptions passed to UploadWithOptions, UploadFileWithOptions and
+	// UploadAt. For the other upload methods, only its Mode is set.
+	Options operator.UploadOptions
+	// Data holds the uploaded content.
+	Data []byte
+	// Owner and Group hold the arguments of Chown.
+	Owner string
+	Group string
+}
+
+// String makes Call values readable in test failure messages.
+func (c Call) String() string {
+	if c.Command != "" {
+		return fmt.Sprintf("%s(%q)", c.Method, c.Command)
+	}
+	return fmt.Sprintf("%s(%q)", c.Method, c.RemotePath)
+}
+
+type response struct {
+	pattern *regexp.Regexp
+	res     operator.CommandRes
+	err     error
+}
+
+// MockOperator implements operator.CommandOperator in memory. Commands return the responses
+// registered with On, and uploaded files are kept so they can be downloaded and inspected.
+// It is safe for concurrent use.
+type MockOperator struct {
+	mu        sync.Mutex
+	responses []response
+	calls     []Call
+	files     map[string][]byte
+	modes     map[string]os.FileMode
+	dirs      map[string]os.FileMode
+	host      string
+}
+
+var _ operator.CommandOperator = &MockOperator{}
+
+func NewMockOperator() *MockOperator {
+	return &MockOperator{
+		files: map[string][]byte{},
+		modes: map[string]os.FileMode{},
+		dirs:  map[string]os.FileMode{},
+		host:  "mock",
+	}
+}
+
+// On makes every command matching the regular expression pattern return res and err.
+// Patterns are tried in the order they were registered. Executing a command that matches
+// no pattern returns an error.
+func (m *MockOperator) On(pattern string, res operator.CommandRes, err error) *MockOperator {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.responses = append(m.responses, response{pattern: regexp.MustCompile(pattern), res: res, err: err})
+	return m
+}
+
+// AddFile stores a remote file, e.g. for a callback that downloads it.
+func (m *MockOperator) AddFile(remotePath string, data []byte, mode os.FileMode) *MockOperator {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[remotePath] = data
+	m.modes[remotePath] = mode
+	return m
+}
+
+// SetHost sets the value returned by Host, which is "mock" by default.
+func (m *MockOperator) SetHost(host string) *MockOperator {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.host = host
+	return m
+}
+
+// File returns the content of a remote file, and whether it exists.
+func (m *MockOperator) File(remotePath string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.files[remotePath]
+	return data, ok
+}
+
+// Calls returns all calls made so far, in order.
+func (m *MockOperator) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Call(nil), m.calls...)
+}
+
+// Commands returns the commands executed so far, in order.
+func (m *MockOperator) Commands() []string {
+	var commands []string
+	for _, call := range m.Calls() {
+		if call.Command != "" {
+			commands = append(commands, call.Command)
+		}
+	}
+	return commands
+}
+
+func (m *MockOperator) record(call Call) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, call)
+}
+
+func (m *MockOperator) respond(command string) (operator.CommandRes, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.responses {
+		if r.pattern.MatchString(command) {
+			return r.res, r.err
+		}
+	}
+	return operator.CommandRes{}, errors.Errorf("operatortest: no response registered for command %q", command)
+}
+
+func (m *MockOperator) Execute(command string) (operator.CommandRes, error) {
+	m.record(Call{Method: "Execute", Command: command})
+	return m.respond(command)
+}
+
+func (m *MockOperator) ExecuteWithStdin(command string, stdin io.Reader) (operator.CommandRes, error) {
+	call := Call{Method: "ExecuteWithStdin", Command: command}
+	if stdin != nil {
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return operator.CommandRes{}, err
+		}
+		call.Stdin = data
+	}
+	m.record(call)
+	return m.respond(command)
+}
+
+// ExecuteAll responds to every command like Execute, recording them as ExecuteAll calls.
+func (m *MockOperator) ExecuteAll(commands []string, stopOnError bool) ([]operator.CommandRes, error) {
+	results := make([]operator.CommandRes, 0, len(commands))
+	errs := make([]error, len(commands))
+	failed := false
+
+	for i, command := range commands {
+		m.record(Call{Method: "ExecuteAll", Command: command})
+		res, err := m.respond(command)
+		results = append(results, res)
+		if err == nil {
+			continue
+		}
+		if stopOnError {
+			return results, err
+		}
+		errs[i] = err
+		failed = true
+	}
+
+	if failed {
+		return results, &operator.BatchError{Commands: commands, Errors: errs}
+	}
+	return results, nil
+}
+
+func (m *MockOperator) ExecuteTimeout(command string, timeout time.Duration) (operator.CommandRes, error) {
+	m.record(Call{Method: "ExecuteTimeout", Command: command})
+	return m.respond(command)
+}
+
+// ExecuteScript responds with the response registered for a pattern matching the script.
+func (m *MockOperator) ExecuteScript(script string) (operator.CommandRes, error) {
+	return m.ExecuteScriptWithOptions(script, operator.ScriptOptions{})
+}
+
+func (m *MockOperator) ExecuteScriptWithOptions(script string, opts operator.ScriptOptions) (operator.CommandRes, error) {
+	m.record(Call{Method: "ExecuteScript", Command: script})
+	return m.respond(script)
+}
+
+func (m *MockOperator) ExecuteSudo(command string, password string) (operator.CommandRes, error) {
+	m.record(Call{Method: "ExecuteSudo", Command: command})
+	return m.respond(command)
+}
+
+func (m *MockOperator) ExecuteStream(command string, stdout io.Writer, stderr io.Writer) (operator.CommandRes, error) {
+	m.record(Call{Method: "ExecuteStream", Command: command})
+	res, err := m.respond(command)
+
+	if stdout != nil {
+		stdout.Write(res.StdOut)
+	}
+	if stderr != nil {
+		stderr.Write(res.StdErr)
+	}
+	res.StdOut = nil
+	res.StdErr = nil
+
+	return res, err
+}
+
+// ExecuteCombined returns the registered StdOut followed by StdErr.
+func (m *MockOperator) ExecuteCombined(command string) ([]byte, int, error) {
+	m.record(Call{Method: "ExecuteCombined", Command: command})
+	res, err := m.respond(command)
+
+	return append(append([]byte(nil), res.StdOut...), res.StdErr...), res.ExitCode, err
+}
+
+// ExecuteString returns the registered StdOut without surrounding white space.
+func (m *MockOperator) ExecuteString(command string) (string, error) {
+	m.record(Call{Method: "ExecuteString", Command: command})
+	res, err := m.respond(command)
+
+	return strings.TrimSpace(string(res.StdOut)), err
+}
+
+// ExecuteUntil responds once, as the registered response does not change between attempts.
+// When predicate does not accept it, the error is an *operator.DeadlineError right away.
+func (m *MockOperator) ExecuteUntil(command string, predicate func(operator.CommandRes) bool, interval time.Duration, deadline time.Time) (operator.CommandRes, error) {
+	m.record(Call{Method: "ExecuteUntil", Command: command})
+	res, err := m.respond(command)
+
+	var commandErr *operator.CommandError
+	if err != nil && !errors.As(err, &commandErr) {
+		return res, err
+	}
+	if !predicate(res) {
+		return res, &operator.DeadlineError{Command: command, Attempts: 1, Err: err}
+	}
+	return res, nil
+}
+
+func (m *MockOperator) Upload(src io.Reader, remotePath string, mode string) error {
+	return m.upload("Upload", "", src, remotePath, operator.UploadOptions{Mode: mode})
+}
+
+func (m *MockOperator) WriteFile(remotePath string, data []byte, mode string) error {
+	return m.upload("WriteFile", "", bytes.NewReader(data), remotePath, operator.UploadOptions{Mode: mode})
+}
+
+func (m *MockOperator) UploadFile(path string, remotePath string, mode string) error {
+	return m.uploadFile("UploadFile", path, remotePath, operator.UploadOptions{Mode: mode})
+}
+
+func (m *MockOperator) UploadWithOptions(src io.Reader, remotePath string, opts operator.UploadOptions) error {
+	if err := m.upload("UploadWithOptions", "", src, remotePath, opts); err != nil {
+		return err
+	}
+	m.reportUpload(remotePath, opts.Progress, false)
+	return nil
+}
+
+func (m *MockOperator) UploadFileWithOptions(path string, remotePath string, opts operator.UploadOptions) error {
+	if err := m.uploadFile("UploadFileWithOptions", path, remotePath, opts); err != nil {
+		return err
+	}
+	m.reportUpload(remotePath, opts.Progress, true)
+	return nil
+}
+
+// UploadAt stores size bytes of src. With an Offset, they replace the stored file from there on.
+func (m *MockOperator) UploadAt(src io.ReaderAt, size int64, remotePath string, opts operator.UploadOptions) error {
+	if opts.Offset < 0 || opts.Offset > size {
+		return errors.Errorf("invalid offset %d for uploading %d bytes to %s", opts.Offset, size, remotePath)
+	}
+
+	data, err := ioutil.ReadAll(io.NewSectionReader(src, opts.Offset, size-opts.Offset))
+	if err != nil {
+		return err
+	}
+	m.record(Call{Method: "UploadAt", RemotePath: remotePath, Mode: opts.Mode, Options: opts, Data: data})
+
+	if opts.Offset > 0 {
+		existing, ok := m.File(remotePath)
+		if !ok {
+			return &os.PathError{Op: "open", Path: remotePath, Err: os.ErrNotExist}
+		}
+		if int64(len(existing)) < opts.Offset {
+			return errors.Errorf("unable to resume the upload of %s at offset %d: the file has only %d bytes", remotePath, opts.Offset, len(existing))
+		}
+		data = append(append([]byte(nil), existing[:opts.Offset]...), data...)
+	}
+
+	if err := m.store(remotePath, data, opts.Mode); err != nil {
+		return err
+	}
+	m.reportUpload(remotePath, opts.Progress, true)
+	return nil
+}
+
+func (m *MockOperator) UploadN(src io.Reader, remotePath string, mode string) (int64, error) {
+	if err := m.upload("UploadN", "", src, remotePath, operator.UploadOptions{Mode: mode}); err != nil {
+		return 0, err
+	}
+	data, _ := m.File(remotePath)
+	return int64(len(data)), nil
+}
+
+func (m *MockOperator) UploadFileN(path string, remotePath string, mode string) (int64, error) {
+	if err := m.uploadFile("UploadFileN", path, remotePath, operator.UploadOptions{Mode: mode}); err != nil {
+		return 0, err
+	}
+	data, _ := m.File(remotePath)
+	return int64(len(data)), nil
+}
+
+// reportUpload reports a completed upload once, with an unknown total unless the size was known.
+func (m *MockOperator) reportUpload(remotePath string, progress operator.ProgressFunc, sized bool) {
+	if progress == nil {
+		return
+	}
+	data, _ := m.File(remotePath)
+	total := int64(-1)
+	if sized {
+		total = int64(len(data))
+	}
+	progress(int64(len(data)), total)
+}
+
+// UploadFileVerified uploads like UploadFile. The stored copy always matches.
+func (m *MockOperator) UploadFileVerified(path string, remotePath string, mode string) error {
+	return m.uploadFile("UploadFileVerified", path, remotePath, operator.UploadOptions{Mode: mode})
+}
+
+func (m *MockOperator) uploadFile(method string, path string, remotePath string, opts operator.UploadOptions) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return m.upload(method, path, source, remotePath, opts)
+}
+
+func (m *MockOperator) upload(method string, path string, src io.Reader, remotePath string, opts operator.UploadOptions) error {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	m.record(Call{Method: method, Path: path, RemotePath: remotePath, Mode: opts.Mode, Options: opts, Data: data})
+	return m.store(remotePath, data, opts.Mode)
+}
+
+func (m *MockOperator) store(remotePath string, data []byte, mode string) error {
+	permissions := os.FileMode(0644)
+	if mode != "" {
+		parsed, err := operator.ParseMode(mode)
+		if err != nil {
+			return err
+		}
+		permissions = parsed
+	}
+
+	m.AddFile(remotePath, data, permissions)
+	return nil
+}
+
+func (m *MockOperator) UploadDir(localDir string, remoteDir string, mode string) error {
+	m.record(Call{Method: "UploadDir", Path: localDir, RemotePath: remoteDir, Mode: mode})
+
+	return filepath.Walk(localDir, func(current string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, current)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(current)
+		if err != nil {
+			return err
+		}
+
+		return m.store(path.Join(remoteDir, filepath.ToSlash(rel)), data, mode)
+	})
+}
+
+// Sync stores the files below localDir whose content differs from the stored one. With
+// opts.Delete, stored files below remoteDir which do not exist locally are removed.
+func (m *MockOperator) Sync(localDir string, remoteDir string, opts operator.SyncOptions) ([]string, error) {
+	m.record(Call{Method: "Sync", Path: localDir, RemotePath: remoteDir, Mode: opts.Mode})
+
+	changed := []string{}
+	local := map[string]bool{}
+
+	err := filepath.Walk(localDir, func(current string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, current)
+		if err != nil {
+			return err
+		}
+		remotePath := path.Join(remoteDir, filepath.ToSlash(rel))
+		local[remotePath] = true
+
+		data, err := ioutil.ReadFile(current)
+		if err != nil {
+			return err
+		}
+		if existing, ok := m.File(remotePath); ok && bytes.Equal(existing, data) {
+			return nil
+		}
+
+		mode := opts.Mode
+		if mode == "" {
+			mode = fmt.Sprintf("%04o", info.Mode().Perm())
+		}
+		if err := m.store(remotePath, data, mode); err != nil {
+			return err
+		}
+		changed = append(changed, remotePath)
+		return nil
+	})
+	if err != nil || !opts.Delete {
+		return changed, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	prefix := strings.TrimSuffix(remoteDir, "/") + "/"
+	for remotePath := range m.files {
+		if strings.HasPrefix(remotePath, prefix) && !local[remotePath] {
+			removed = append(removed, remotePath)
+		}
+	}
+	sort.Strings(removed)
+
+	for _, remotePath := range removed {
+		delete(m.files, remotePath)
+		delete(m.modes, remotePath)
+	}
+
+	return append(changed, removed...), nil
+}
+
+func (m *MockOperator) UploadGlob(pattern string, remoteDir string, mode string) ([]string, error) {
+	m.record(Call{Method: "UploadGlob", Path: pattern, RemotePath: remoteDir, Mode: mode})
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern: %s", pattern)
+	}
+
+	uploaded := []string{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return uploaded, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(match)
+		if err != nil {
+			return uploaded, err
+		}
+
+		remotePath := path.Join(remoteDir, filepath.Base(match))
+		if err := m.store(remotePath, data, mode); err != nil {
+			return uploaded, err
+		}
+		uploaded = append(uploaded, remotePath)
+	}
+
+	return uploaded, nil
+}
+
+func (m *MockOperator) Download(remotePath string, dst io.Writer) error {
+	return m.download("Download", remotePath, dst, operator.DownloadOptions{})
+}
+
+func (m *MockOperator) DownloadWithOptions(remotePath string, dst io.Writer, opts operator.DownloadOptions) error {
+	return m.download("DownloadWithOptions", remotePath, dst, opts)
+}
+
+func (m *MockOperator) download(method string, remotePath string, dst io.Writer, opts operator.DownloadOptions) error {
+	m.record(Call{Method: method, RemotePath: remotePath})
+	return m.copyFile(remotePath, dst, opts)
+}
+
+func (m *MockOperator) ReadFile(remotePath string) ([]byte, error) {
+	m.record(Call{Method: "ReadFile", RemotePath: remotePath})
+
+	data, ok := m.File(remotePath)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: remotePath, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (m *MockOperator) DownloadFile(remotePath string, localPath string) error {
+	return m.downloadFile("DownloadFile", remotePath, localPath, operator.DownloadOptions{})
+}
+
+func (m *MockOperator) DownloadFileWithOptions(remotePath string, localPath string, opts operator.DownloadOptions) error {
+	return m.downloadFile("DownloadFileWithOptions", remotePath, localPath, opts)
+}
+
+func (m *MockOperator) downloadFile(method string, remotePath string, localPath string, opts operator.DownloadOptions) error {
+	m.record(Call{Method: method, Path: localPath, RemotePath: remotePath})
+
+	buffer := bytes.Buffer{}
+	if err := m.copyFile(remotePath, &buffer, opts); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(localPath, buffer.Bytes(), 0644)
+}
+
+// copyFile writes a stored file to dst, reporting the transfer once when complete.
+func (m *MockOperator) copyFile(remotePath string, dst io.Writer, opts operator.DownloadOptions) error {
+	data, ok := m.File(remotePath)
+	if !ok {
+		return &os.PathError{Op: "open", Path: remotePath, Err: os.ErrNotExist}
+	}
+
+	n, err := io.Copy(dst, bytes.NewReader(data))
+	if err == nil && opts.Progress != nil {
+		opts.Progress(n, int64(len(data)))
+	}
+	return err
+}
+
+// Tail writes the contents of remotePath to out and blocks until ctx is done, like following
+// a file nothing is appended to.
+func (m *MockOperator) Tail(ctx context.Context, remotePath string, out io.Writer) error {
+	m.record(Call{Method: "Tail", RemotePath: remotePath})
+
+	data, ok := m.File(remotePath)
+	if !ok {
+		return &os.PathError{Op: "open", Path: remotePath, Err: os.ErrNotExist}
+	}
+	if out != nil {
+		out.Write(data)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// Host returns the host set with SetHost. Unlike the other CommandOperator methods, it is not
+// recorded as a call.
+func (m *MockOperator) Host() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.host
+}
+
+func (m *MockOperator) Ping() error {
+	m.record(Call{Method: "Ping"})
+	return nil
+}
+
+// Stat reports the stored files, the directories created with Mkdir and MkdirAll, and the
+// directories containing stored files.
+func (m *MockOperator) Stat(remotePath string) (os.FileInfo, error) {
+	m.record(Call{Method: "Stat", RemotePath: remotePath})
+	return m.lockedStat(remotePath)
+}
+
+// lockedStat is stat for the methods which do not hold the lock, without recording a Stat call.
+func (m *MockOperator) lockedStat(remotePath string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stat(remotePath)
+}
+
+func (m *MockOperator) stat(remotePath string) (os.FileInfo, error) {
+	if data, ok := m.files[remotePath]; ok {
+		return fileInfo{name: path.Base(remotePath), size: int64(len(data)), mode: m.modes[remotePath]}, nil
+	}
+
+	if mode, ok := m.dirs[remotePath]; ok {
+		return fileInfo{name: path.Base(remotePath), mode: os.ModeDir | mode}, nil
+	}
+
+	if len(m.children(remotePath)) > 0 {
+		return fileInfo{name: path.Base(remotePath), mode: os.ModeDir | 0755}, nil
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: remotePath, Err: os.ErrNotExist}
+}
+
+// children returns the stored files and directories below dir.
+func (m *MockOperator) children(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	var children []string
+	for _, name := range m.fileNames() {
+		if strings.HasPrefix(name, prefix) {
+			children = append(children, name)
+		}
+	}
+	for name := range m.dirs {
+		if strings.HasPrefix(name, prefix) {
+			children = append(children, name)
+		}
+	}
+	return children
+}
+
+func (m *MockOperator) Mkdir(remotePath string, mode string) error {
+	m.record(Call{Method: "Mkdir", RemotePath: remotePath, Mode: mode})
+
+	permissions, err := operator.ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(remotePath); err == nil {
+		return &os.PathError{Op: "mkdir", Path: remotePath, Err: os.ErrExist}
+	}
+	m.dirs[remotePath] = permissions
+	return nil
+}
+
+func (m *MockOperator) MkdirAll(remotePath string, mode string) error {
+	m.record(Call{Method: "MkdirAll", RemotePath: remotePath, Mode: mode})
+
+	permissions, err := operator.ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var missing []string
+	for dir := remotePath; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		info, err := m.stat(dir)
+		if err == nil && !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		if err != nil {
+			missing = append(missing, dir)
+		}
+	}
+	for _, dir := range missing {
+		m.dirs[dir] = permissions
+	}
+	return nil
+}
+
+func (m *MockOperator) Remove(remotePath string) error {
+	m.record(Call{Method: "Remove", RemotePath: remotePath})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(remotePath); err != nil {
+		return &os.PathError{Op: "remove", Path: remotePath, Err: os.ErrNotExist}
+	}
+	if len(m.children(remotePath)) > 0 {
+		return &os.PathError{Op: "remove", Path: remotePath, Err: syscall.ENOTEMPTY}
+	}
+
+	delete(m.files, remotePath)
+	delete(m.modes, remotePath)
+	delete(m.dirs, remotePath)
+	return nil
+}
+
+// Chmod changes the mode of a stored file or directory.
+func (m *MockOperator) Chmod(remotePath string, mode os.FileMode) error {
+	m.record(Call{Method: "Chmod", RemotePath: remotePath, Mode: fmt.Sprintf("%04o", mode)})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(remotePath); err != nil {
+		return &os.PathError{Op: "chmod", Path: remotePath, Err: os.ErrNotExist}
+	}
+	if _, ok := m.files[remotePath]; ok {
+		m.modes[remotePath] = mode
+	} else {
+		m.dirs[remotePath] = mode
+	}
+	return nil
+}
+
+// Chown only records the call, the mock does not keep track of owners.
+func (m *MockOperator) Chown(remotePath string, owner string, group string) error {
+	m.record(Call{Method: "Chown", RemotePath: remotePath, Owner: owner, Group: group})
+
+	if _, err := m.lockedStat(remotePath); err != nil {
+		return &os.PathError{Op: "chown", Path: remotePath, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+// Rename moves a stored file, or a directory with everything below it.
+func (m *MockOperator) Rename(oldPath string, newPath string) error {
+	m.record(Call{Method: "Rename", Path: oldPath, RemotePath: newPath})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stat(oldPath); err != nil {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: os.ErrNotExist}
+	}
+
+	move := func(name string) string {
+		return newPath + strings.TrimPrefix(name, oldPath)
+	}
+	for _, name := range append(m.children(oldPath), oldPath) {
+		if data, ok := m.files[name]; ok {
+			m.files[move(name)], m.modes[move(name)] = data, m.modes[name]
+			delete(m.files, name)
+			delete(m.modes, name)
+		}
+		if mode, ok := m.dirs[name]; ok {
+			m.dirs[move(name)] = mode
+			delete(m.dirs, name)
+		}
+	}
+	return nil
+}
+
+func (m *MockOperator) fileNames() []string {
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *MockOperator) Exists(remotePath string) (bool, error) {
+	m.record(Call{Method: "Exists", RemotePath: remotePath})
+
+	_, err := m.lockedStat(remotePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MockOperator) IsDir(remotePath string) (bool, error) {
+	m.record(Call{Method: "IsDir", RemotePath: remotePath})
+
+	info, err := m.lockedStat(remotePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() os.FileMode  { return f.mode }
+func (f fileInfo) ModTime() time.Time { return time.Time{} }
+func (f fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fileInfo) Sys() interface{}   { return nil }
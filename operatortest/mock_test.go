@@ -0,0 +1,121 @@
+package operatortest_test
+
+import (
+	"github.com/jsiebens/operator"
+	"github.com/jsiebens/operator/operatortest"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMockOn(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	mock := operatortest.NewMockOperator().
+		On(`^uname -s$`, operator.CommandRes{StdOut: []byte("Linux\n")}, nil).
+		On(`^systemctl restart`, operator.CommandRes{ExitCode: 1}, errFailed).
+		On(`^systemctl`, operator.CommandRes{StdOut: []byte("active\n")}, nil)
+
+	for _, test := range []struct {
+		command string
+		stdout  string
+		err     error
+		matched bool
+	}{
+		{"uname -s", "Linux\n", nil, true},
+		{"systemctl restart nginx", "", errFailed, true},
+		{"systemctl is-active nginx", "active\n", nil, true},
+		{"uname -s -r", "", nil, false},
+	} {
+		res, err := mock.Execute(test.command)
+		if !test.matched {
+			if err == nil || !strings.Contains(err.Error(), "no response registered") {
+				t.Errorf("%s: expected an error for an unregistered command, got %v", test.command, err)
+			}
+			continue
+		}
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.command, test.err, err)
+		}
+		if got := string(res.StdOut); got != test.stdout {
+			t.Errorf("%s: expected output %q, got %q", test.command, test.stdout, got)
+		}
+	}
+}
+
+func TestMockCalls(t *testing.T) {
+	progress := func(int64, int64) {}
+
+	for _, test := range []struct {
+		name string
+		call func(op operator.CommandOperator) error
+		want operatortest.Call
+	}{
+		{
+			"Execute",
+			func(op operator.CommandOperator) error {
+				_, err := op.Execute("true")
+				return err
+			},
+			operatortest.Call{Method: "Execute", Command: "true"},
+		},
+		{
+			"ExecuteWithStdin",
+			func(op operator.CommandOperator) error {
+				_, err := op.ExecuteWithStdin("true", strings.NewReader("input"))
+				return err
+			},
+			operatortest.Call{Method: "ExecuteWithStdin", Command: "true", Stdin: []byte("input")},
+		},
+		{
+			"Upload",
+			func(op operator.CommandOperator) error {
+				return op.Upload(strings.NewReader("data"), "/etc/app.conf", "0600")
+			},
+			operatortest.Call{Method: "Upload", RemotePath: "/etc/app.conf", Mode: "0600", Options: operator.UploadOptions{Mode: "0600"}, Data: []byte("data")},
+		},
+		{
+			"UploadWithOptions",
+			func(op operator.CommandOperator) error {
+				return op.UploadWithOptions(strings.NewReader("data"), "/etc/app.conf", operator.UploadOptions{Mode: "0640", Owner: "app", Atomic: true, MkdirParents: true})
+			},
+			operatortest.Call{Method: "UploadWithOptions", RemotePath: "/etc/app.conf", Mode: "0640", Options: operator.UploadOptions{Mode: "0640", Owner: "app", Atomic: true, MkdirParents: true}, Data: []byte("data")},
+		},
+		{
+			"UploadAt",
+			func(op operator.CommandOperator) error {
+				return op.UploadAt(strings.NewReader("data"), 4, "/srv/blob", operator.UploadOptions{Sudo: true, Group: "www"})
+			},
+			operatortest.Call{Method: "UploadAt", RemotePath: "/srv/blob", Options: operator.UploadOptions{Sudo: true, Group: "www"}, Data: []byte("data")},
+		},
+		{
+			"Stat",
+			func(op operator.CommandOperator) error {
+				op.Stat("/etc")
+				return nil
+			},
+			operatortest.Call{Method: "Stat", RemotePath: "/etc"},
+		},
+	} {
+		mock := operatortest.NewMockOperator().On(`.*`, operator.CommandRes{}, nil)
+		if err := test.call(mock); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		calls := mock.Calls()
+		if len(calls) != 1 || !reflect.DeepEqual(calls[0], test.want) {
+			t.Errorf("%s: expected call %#v, got %#v", test.name, test.want, calls)
+		}
+	}
+
+	// functions cannot be compared with DeepEqual, so the progress callback is checked apart
+	mock := operatortest.NewMockOperator()
+	if err := mock.UploadWithOptions(strings.NewReader("data"), "/tmp/file", operator.UploadOptions{Progress: progress}); err != nil {
+		t.Fatal(err)
+	}
+	if mock.Calls()[0].Options.Progress == nil {
+		t.Error("expected the Progress option to be recorded")
+	}
+}
@@ -0,0 +1,482 @@
+package operatortest
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StartTestServer starts an SSH server on a random loopback port, for integration tests of
+// code that uses an SSHOperator. It accepts any user with any password or public key, and
+// serves SFTP from the local file system, so uploads and downloads work as usual.
+//
+// Commands are not run by a shell. The server answers a few canned ones: echo, cat (which
+// copies the given files, or stdin, to stdout), pwd, true, false, exit N, mkdir -p and scp -t,
+// which receives an uploaded file and reports errors on stderr. These cover the fallbacks of
+// an SSHOperator for servers without SFTP. Any other command fails with exit code 127. The
+// host key is generated on every start.
+//
+// It returns the address of the server and a func which stops it and closes all connections.
+func StartTestServer(opts ...ServerOption) (string, func(), error) {
+	options := ServerOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unable to generate host key")
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unable to generate host key")
+	}
+
+	config := &ssh.ServerConfig{
+		Config: ssh.Config{
+			Ciphers: options.Ciphers,
+		},
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unable to start test server")
+	}
+
+	server := &testServer{
+		listener: listener,
+		config:   config,
+		disk:     &disk{space: options.DiskSpace},
+		conns:    map[net.Conn]struct{}{},
+	}
+	go server.serve()
+
+	return listener.Addr().String(), server.close, nil
+}
+
+// ServerOptions configures the server started by StartTestServer.
+type ServerOptions struct {
+	// Ciphers lists the cipher algorithms the server accepts. When empty, the defaults of
+	// golang.org/x/crypto/ssh are used.
+	Ciphers []string
+	// DiskSpace is the number of bytes which can be written to files over SFTP and scp. Once
+	// it is used up, writes fail with "no space left on device". Zero means unlimited.
+	DiskSpace int64
+}
+
+// ServerOption sets a field of ServerOptions.
+type ServerOption func(*ServerOptions)
+
+// WithCiphers restricts the server to the given cipher algorithms, e.g. "aes128-cbc" to
+// mimic a legacy appliance.
+func WithCiphers(ciphers ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.Ciphers = ciphers
+	}
+}
+
+// WithDiskSpace simulates a disk which fills up after bytes have been written to files over
+// SFTP and scp, in total over the lifetime of the server, to test the handling of a full disk.
+func WithDiskSpace(bytes int64) ServerOption {
+	return func(o *ServerOptions) {
+		o.DiskSpace = bytes
+	}
+}
+
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	disk     *disk
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *testServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+
+	go func() {
+		for request := range requests {
+			if request.WantReply {
+				request.Reply(request.Type == "keepalive@openssh.com", nil)
+			}
+		}
+	}()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, requests)
+	}
+}
+
+// session serves a single session channel until it is closed.
+func (s *testServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for request := range requests {
+		switch request.Type {
+		case "env", "pty-req":
+			request.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+			go func() {
+				exit(channel, s.run(payload.Command, channel, channel, channel.Stderr()))
+			}()
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(request.Payload, &payload); err != nil || payload.Name != "sftp" {
+				request.Reply(false, nil)
+				continue
+			}
+			server, err := s.sftpServer(channel)
+			if err != nil {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+			go func() {
+				server.Serve()
+				exit(channel, 0)
+			}()
+		default:
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// sftpServer serves SFTP from the local file system on channel, with writes counted against
+// the disk when its space is limited.
+func (s *testServer) sftpServer(channel ssh.Channel) (interface{ Serve() error }, error) {
+	if s.disk.space == 0 {
+		return sftp.NewServer(channel)
+	}
+	return sftp.NewRequestServer(channel, fileSystem{disk: s.disk}.handlers()), nil
+}
+
+// exit reports the exit code of the session and closes it.
+func exit(channel ssh.Channel, code int) {
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(code)}))
+	channel.Close()
+}
+
+// run executes one of the canned commands and returns its exit code.
+func (s *testServer) run(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	args, err := fields(command)
+	if err != nil {
+		fmt.Fprintf(stderr, "sh: %s\n", err)
+		return 2
+	}
+	if len(args) == 0 {
+		return 0
+	}
+
+	switch args[0] {
+	case "echo":
+		fmt.Fprintln(stdout, strings.Join(args[1:], " "))
+		return 0
+	case "cat":
+		return cat(operands(args[1:]), stdin, stdout, stderr)
+	case "mkdir":
+		return mkdir(args[1:], stderr)
+	case "pwd":
+		dir, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintf(stderr, "pwd: %s\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, dir)
+		return 0
+	case "true":
+		return 0
+	case "false":
+		return 1
+	case "exit":
+		if len(args) < 2 {
+			return 0
+		}
+		code, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(stderr, "sh: exit: %s: numeric argument required\n", args[1])
+			return 2
+		}
+		return code
+	case "scp":
+		return s.scp(args[1:], stdin, stdout, stderr)
+	}
+
+	// drain stdin so a client writing to it does not block
+	io.Copy(ioutil.Discard, stdin)
+	fmt.Fprintf(stderr, "sh: %s: command not found\n", args[0])
+	return 127
+}
+
+// operands returns args without a leading "--", which ends the options of a command.
+func operands(args []string) []string {
+	if len(args) > 0 && args[0] == "--" {
+		return args[1:]
+	}
+	return args
+}
+
+// cat copies the files at paths to stdout, or stdin when no path is given.
+func cat(paths []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(paths) == 0 {
+		if _, err := io.Copy(stdout, stdin); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "cat: %s\n", err)
+			return 1
+		}
+		_, err = io.Copy(stdout, file)
+		file.Close()
+		if err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// mkdir creates directories like mkdir -p [-m mode], the only form the server supports.
+func mkdir(args []string, stderr io.Writer) int {
+	parents := false
+	mode := os.FileMode(0755)
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "--" {
+		switch args[0] {
+		case "-p":
+			parents = true
+		case "-m":
+			if len(args) < 2 {
+				fmt.Fprintln(stderr, "mkdir: option requires an argument -- m")
+				return 1
+			}
+			perm, err := strconv.ParseUint(args[1], 8, 32)
+			if err != nil {
+				fmt.Fprintf(stderr, "mkdir: invalid mode %s\n", args[1])
+				return 1
+			}
+			mode = os.FileMode(perm)
+			args = args[1:]
+		default:
+			fmt.Fprintf(stderr, "mkdir: unsupported option %s\n", args[0])
+			return 1
+		}
+		args = args[1:]
+	}
+	if !parents {
+		fmt.Fprintln(stderr, "mkdir: only mkdir -p is supported")
+		return 1
+	}
+
+	for _, dir := range operands(args) {
+		if err := os.MkdirAll(dir, mode); err != nil {
+			fmt.Fprintf(stderr, "mkdir: %s\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// scp receives a single file like scp -t does: it reads a C line with the mode, size and name
+// of the file, and writes the file to the target, or into it when the target is a directory.
+func (s *testServer) scp(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	sink := false
+	target := ""
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			sink = sink || strings.Contains(arg, "t")
+		} else {
+			target = arg
+		}
+	}
+	if !sink || target == "" {
+		fmt.Fprintln(stderr, "usage: scp -t target")
+		return 1
+	}
+
+	reader := bufio.NewReader(stdin)
+	stdout.Write([]byte{0})
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 1
+	}
+	var mode uint32
+	var size int64
+	var name string
+	if _, err := fmt.Sscanf(line, "C%o %d %s", &mode, &size, &name); err != nil {
+		return scpError(stdout, stderr, "protocol error: %s", strings.TrimSpace(line))
+	}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		target = filepath.Join(target, name)
+	}
+	stdout.Write([]byte{0})
+
+	if err := s.receive(target, os.FileMode(mode), reader, size); err != nil {
+		// keep reading like scp, so the client is not blocked writing the rest of the file
+		io.Copy(ioutil.Discard, reader)
+		if pathErr, ok := err.(*os.PathError); ok {
+			err = pathErr.Err
+		}
+		return scpError(stdout, stderr, "%s: %s", target, err)
+	}
+
+	reader.ReadByte()
+	stdout.Write([]byte{0})
+	return 0
+}
+
+// receive writes size bytes of source to path, counting them against the disk.
+func (s *testServer) receive(path string, mode os.FileMode, source io.Reader, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	// hides the ReadFrom method of the file, which would bypass the disk
+	destination := struct{ io.Writer }{diskFile{File: file, disk: s.disk}}
+	_, err = io.CopyN(destination, source, size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// scpError reports an error in the scp protocol on stdout, and as a message on stderr.
+func scpError(stdout io.Writer, stderr io.Writer, format string, args ...interface{}) int {
+	message := "scp: " + fmt.Sprintf(format, args...)
+	fmt.Fprintf(stdout, "\x01%s\n", message)
+	fmt.Fprintln(stderr, message)
+	return 1
+}
+
+// fields splits command into words like a POSIX shell, honoring single and double quotes and
+// backslash escapes, but without any expansion.
+func fields(command string) ([]string, error) {
+	var words []string
+	var current strings.Builder
+	inWord := false
+	quote := byte(0)
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(command) && (command[i+1] == '"' || command[i+1] == '\\') {
+				i++
+				current.WriteByte(command[i])
+			} else {
+				current.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\' && i+1 < len(command):
+			i++
+			current.WriteByte(command[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
+}
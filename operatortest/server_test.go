@@ -0,0 +1,210 @@
+package operatortest_test
+
+import (
+	"bytes"
+	"github.com/jsiebens/operator"
+	"github.com/jsiebens/operator/operatortest"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dial starts a test server and connects to it. The returned func closes the operator and
+// stops the server.
+func dial(t *testing.T, opts ...operator.Option) (*operator.SSHOperator, func()) {
+	t.Helper()
+
+	address, stop, err := operatortest.StartTestServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, portString, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portString)
+
+	op, err := operator.Dial(host, port, "test", ssh.Password("test"), opts...)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+
+	return op, func() {
+		op.Close()
+		stop()
+	}
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "operatortest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestServerCommands(t *testing.T) {
+	op, stop := dial(t)
+	defer stop()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		command  string
+		stdout   string
+		exitCode int
+	}{
+		{"echo hello world", "hello world\n", 0},
+		{"echo 'quoted  words'", "quoted  words\n", 0},
+		{"pwd", cwd + "\n", 0},
+		{"true", "", 0},
+		{"false", "", 1},
+		{"exit 3", "", 3},
+		{"ls /", "", 127},
+	} {
+		res, err := op.Execute(test.command)
+		if test.exitCode == 0 && err != nil {
+			t.Errorf("%s: %v", test.command, err)
+			continue
+		}
+
+		var commandErr *operator.CommandError
+		if test.exitCode != 0 && (!errors.As(err, &commandErr) || commandErr.ExitCode != test.exitCode) {
+			t.Errorf("%s: expected a CommandError with exit code %d, got %v", test.command, test.exitCode, err)
+		}
+		if res.ExitCode != test.exitCode {
+			t.Errorf("%s: expected exit code %d, got %d", test.command, test.exitCode, res.ExitCode)
+		}
+		if got := string(res.StdOut); got != test.stdout {
+			t.Errorf("%s: expected output %q, got %q", test.command, test.stdout, got)
+		}
+	}
+
+	res, err := op.ExecuteWithStdin("cat", strings.NewReader("from stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.StdOut); got != "from stdin" {
+		t.Errorf("cat: expected output %q, got %q", "from stdin", got)
+	}
+}
+
+func TestServerExecuteAll(t *testing.T) {
+	op, stop := dial(t)
+	defer stop()
+
+	commands := []string{"echo one", "false", "echo two"}
+
+	results, err := op.ExecuteAll(commands, true)
+	var commandErr *operator.CommandError
+	if !errors.As(err, &commandErr) || commandErr.Command != "false" {
+		t.Errorf("expected a CommandError for false, got %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("expected 2 results when stopping on error, got %d", len(results))
+	}
+
+	results, err = op.ExecuteAll(commands, false)
+	var batchErr *operator.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected a BatchError, got %v", err)
+	}
+	if len(results) != 3 || string(results[2].StdOut) != "two\n" {
+		t.Errorf("expected all 3 commands to run, got %v", results)
+	}
+	if batchErr.Errors[0] != nil || batchErr.Errors[1] == nil || batchErr.Errors[2] != nil {
+		t.Errorf("expected only the second command to fail, got %v", batchErr.Errors)
+	}
+}
+
+// TestServerFiles round trips files over SFTP, and over scp and cat for servers without it.
+func TestServerFiles(t *testing.T) {
+	for name, opts := range map[string][]operator.Option{
+		"sftp":    nil,
+		"scp/cat": {operator.WithSFTPSubsystem("none")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			op, stop := dial(t, opts...)
+			defer stop()
+
+			dir, remove := tempDir(t)
+			defer remove()
+
+			remotePath := filepath.Join(dir, "sub", "file.txt")
+			if err := op.Upload(strings.NewReader("hello"), remotePath, "0640"); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(remotePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("expected mode 0640, got %04o", info.Mode().Perm())
+			}
+
+			downloaded := bytes.Buffer{}
+			if err := op.Download(remotePath, &downloaded); err != nil {
+				t.Fatal(err)
+			}
+			if got := downloaded.String(); got != "hello" {
+				t.Errorf("expected to download %q, got %q", "hello", got)
+			}
+		})
+	}
+}
+
+func TestServerUploadDirAndSync(t *testing.T) {
+	op, stop := dial(t)
+	defer stop()
+
+	localDir, removeLocal := tempDir(t)
+	defer removeLocal()
+	remoteDir, removeRemote := tempDir(t)
+	defer removeRemote()
+
+	if err := os.MkdirAll(filepath.Join(localDir, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"index.html": "index", "conf/app.ini": "config"} {
+		if err := ioutil.WriteFile(filepath.Join(localDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uploadDir := filepath.Join(remoteDir, "upload")
+	if err := op.UploadDir(localDir, uploadDir, "0644"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(uploadDir, "conf", "app.ini"))
+	if err != nil || string(data) != "config" {
+		t.Errorf("expected conf/app.ini to be uploaded, got %q, %v", data, err)
+	}
+
+	syncDir := filepath.Join(remoteDir, "sync")
+	uploaded, err := op.Sync(localDir, syncDir, operator.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 2 {
+		t.Errorf("expected the first sync to upload 2 files, got %v", uploaded)
+	}
+
+	uploaded, err = op.Sync(localDir, syncDir, operator.SyncOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 0 {
+		t.Errorf("expected the second sync to upload nothing, got %v", uploaded)
+	}
+}
@@ -0,0 +1,179 @@
+package operatortest
+
+import (
+	"github.com/pkg/sftp"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// disk counts the bytes written to files on the test server. Once space bytes have been
+// written, further writes fail with ENOSPC. A space of zero means unlimited.
+type disk struct {
+	mu    sync.Mutex
+	space int64
+	used  int64
+}
+
+// reserve returns how many of n bytes fit on the disk, and accounts for them.
+func (d *disk) reserve(n int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.space == 0 {
+		return n
+	}
+	if left := d.space - d.used; int64(n) > left {
+		n = int(left)
+	}
+	d.used += int64(n)
+	return n
+}
+
+// diskFile is a local file whose writes are counted against a disk.
+type diskFile struct {
+	*os.File
+	disk *disk
+}
+
+func (f diskFile) Write(b []byte) (int, error) {
+	n, err := f.File.Write(b[:f.disk.reserve(len(b))])
+	return n, f.full(n, len(b), err)
+}
+
+func (f diskFile) WriteAt(b []byte, offset int64) (int, error) {
+	n, err := f.File.WriteAt(b[:f.disk.reserve(len(b))], offset)
+	return n, f.full(n, len(b), err)
+}
+
+// full returns ENOSPC when only n of the size bytes of a write fit on the disk.
+func (f diskFile) full(n int, size int, err error) error {
+	if err == nil && n < size {
+		return &os.PathError{Op: "write", Path: f.Name(), Err: syscall.ENOSPC}
+	}
+	return err
+}
+
+// fileSystem serves SFTP requests from the local file system, counting the bytes written
+// against disk. It backs the SFTP subsystem when the disk space is limited, as the server of
+// github.com/pkg/sftp cannot intercept writes.
+type fileSystem struct {
+	disk *disk
+}
+
+func (f fileSystem) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: f, FilePut: f, FileCmd: f, FileList: f}
+}
+
+func (f fileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(r.Filepath)
+}
+
+func (f fileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return f.open(r)
+}
+
+func (f fileSystem) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return f.open(r)
+}
+
+func (f fileSystem) open(r *sftp.Request) (diskFile, error) {
+	pflags := r.Pflags()
+
+	flag := os.O_WRONLY
+	if pflags.Read {
+		flag = os.O_RDWR
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(r.Filepath, flag, 0644)
+	if err != nil {
+		return diskFile{}, err
+	}
+	return diskFile{File: file, disk: f.disk}, nil
+}
+
+func (f fileSystem) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return setstat(r)
+	case "Rename":
+		return os.Rename(r.Filepath, r.Target)
+	case "Mkdir":
+		return os.Mkdir(r.Filepath, 0755)
+	case "Rmdir", "Remove":
+		return os.Remove(r.Filepath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (f fileSystem) PosixRename(r *sftp.Request) error {
+	return os.Rename(r.Filepath, r.Target)
+}
+
+func (f fileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		infos, err := ioutil.ReadDir(r.Filepath)
+		return listerAt(infos), err
+	case "Stat":
+		info, err := os.Stat(r.Filepath)
+		return listerAt{info}, err
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (f fileSystem) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := os.Lstat(r.Filepath)
+	return listerAt{info}, err
+}
+
+// setstat applies the attributes of a Setstat request.
+func setstat(r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+
+	if flags.Size {
+		if err := os.Truncate(r.Filepath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(r.Filepath, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err := os.Chown(r.Filepath, int(attrs.UID), int(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		return os.Chtimes(r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0))
+	}
+	return nil
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,266 @@
+package operator
+
+import (
+	"context"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+// Option configures the optional behaviour of the Execute functions.
+type Option func(*Options)
+
+// Options holds the settings used when connecting to a remote host.
+type Options struct {
+	// User is the login user. It is ignored by the functions that take the user as an argument.
+	// When empty, the current local user is used.
+	User string
+	// Auth lists the authentication methods to try. It is ignored by the functions that take
+	// the credentials as an argument.
+	Auth []ssh.AuthMethod
+
+	// HostKeyCallback verifies the host key of the remote host.
+	//
+	// WARNING: when HostKeyCallback is nil and ExpectedFingerprint and KnownHostsFile are
+	// empty, host keys are NOT verified at all (ssh.InsecureIgnoreHostKey), which leaves the
+	// connection open to man-in-the-middle attacks. This default only exists for backwards
+	// compatibility.
+	HostKeyCallback ssh.HostKeyCallback
+	// ExpectedFingerprint is the SHA-256 fingerprint the host key of the remote host must have
+	// when HostKeyCallback is nil, in the "SHA256:..." format of ssh-keygen -l.
+	ExpectedFingerprint string
+	// KnownHostsFile is the OpenSSH known_hosts file used to verify the host key of the
+	// remote host when HostKeyCallback is nil.
+	KnownHostsFile string
+	// TrustOnFirstUse adds the host keys of hosts missing from KnownHostsFile to it instead
+	// of rejecting them.
+	TrustOnFirstUse bool
+	// OnHostKey receives every host key which passed the verification.
+	OnHostKey func(hostname string, remote net.Addr, key ssh.PublicKey)
+
+	// PassphraseFunc returns the passphrase of a passphrase-protected private key. When nil,
+	// the passphrase is prompted for on the terminal, if stdin is one.
+	PassphraseFunc PassphraseFunc
+	// PassphraseEnv is the name of an environment variable holding the passphrase of
+	// passphrase-protected private keys. It is used when PassphraseFunc is nil.
+	PassphraseEnv string
+	// DisableInteractivePrompts never reads passphrases or keyboard-interactive answers from
+	// the terminal. A passphrase or challenge that is needed but not supplied is an error.
+	DisableInteractivePrompts bool
+
+	// CertificateFile is the OpenSSH certificate presented together with the private key.
+	// When empty, the "-cert.pub" file next to the private key is used if it exists.
+	CertificateFile string
+
+	// LegacyFallback retries a handshake without a common algorithm once with insecure legacy
+	// algorithms enabled.
+	LegacyFallback bool
+
+	// ClientVersion is the identification string sent to the server in the SSH handshake.
+	// When empty, DefaultClientVersion is used.
+	ClientVersion string
+
+	// DialTimeout is the maximum amount of time for the connection to a host, including the
+	// SSH handshake, to establish. Zero means DefaultDialTimeout, a negative value disables
+	// the timeout. It does not limit how long commands may run.
+	DialTimeout time.Duration
+	// Timeout is the maximum amount of time for the TCP connection to establish.
+	//
+	// Deprecated: use DialTimeout, which takes precedence when set.
+	Timeout time.Duration
+	// RetryAttempts is the number of times a connection is attempted. Values below 2 disable retries.
+	RetryAttempts int
+	// RetryInterval is the time to wait between two connection attempts.
+	RetryInterval time.Duration
+
+	// ResultHandler receives the Result of every target of ExecuteParallel as soon as it is done.
+	ResultHandler func(Result)
+
+	// KeepaliveInterval is the time between two keepalive requests. Zero disables keepalives.
+	KeepaliveInterval time.Duration
+	// KeepaliveMaxFailures is the number of keepalives in a row which may fail before the
+	// connection is closed.
+	KeepaliveMaxFailures int
+
+	// InterruptGracePeriod, when positive, is the time a remote command is given to exit after
+	// SIGINT and again after SIGTERM when its context is done, before it is killed.
+	InterruptGracePeriod time.Duration
+
+	// MaxSessions is the maximum number of sessions open at the same time on a connection,
+	// including the SFTP session of the file methods. Zero means DefaultMaxSessions, values
+	// below 2 are raised to 2, and a negative value disables the limit.
+	MaxSessions int
+
+	// SFTPMaxConcurrentRequests is the maximum number of SFTP requests in flight per
+	// transferred file. Zero means the default of github.com/pkg/sftp.
+	SFTPMaxConcurrentRequests int
+	// SFTPConcurrentWrites sends the SFTP writes of an upload without waiting for the previous
+	// ones to be acknowledged. It is off by default.
+	SFTPConcurrentWrites bool
+	// SFTPSubsystem is the subsystem requested for SFTP sessions, or the path of the SFTP server
+	// program to execute when it contains a slash. When empty, the "sftp" subsystem is used.
+	SFTPSubsystem string
+	// SCPPath is the remote scp program used when SFTP is not available. When empty, scp is
+	// looked up in the PATH of the remote user.
+	SCPPath string
+
+	// ForwardAgent forwards the local SSH agent to the remote host. It is off by default.
+	ForwardAgent bool
+	// DisableAgent keeps passphrase-protected keys from being used through the SSH agent.
+	DisableAgent bool
+
+	// CloseClient closes the client passed to NewSSHOperatorFromClient together with the operator.
+	CloseClient bool
+
+	// Logger receives every executed command and uploaded file.
+	Logger Logger
+	// Tracer is called around every dial, executed command and upload.
+	Tracer TraceFunc
+
+	// ReadFileLimit is the maximum size in bytes of a file read with ReadFile. Zero means no limit.
+	ReadFileLimit int64
+	// MaxOutputBytes is the maximum number of bytes of the standard output and of the standard
+	// error kept in a CommandRes. Zero means no limit.
+	MaxOutputBytes int64
+
+	// Shell is the shell remote commands are wrapped in.
+	Shell Shell
+	// RemoteTempDir is the directory temporary files like scripts are written to. When empty,
+	// /tmp is used on remote hosts and os.TempDir locally.
+	RemoteTempDir string
+	// WorkingDir is the directory commands are executed in. When empty, remote commands run in
+	// the home directory of the user and local commands in the current directory.
+	WorkingDir string
+	// Env holds environment variables for every executed command.
+	Env map[string]string
+	// EnvStrategy controls how Env is passed to remote commands.
+	EnvStrategy EnvStrategy
+	// Elevation is the tool ExecuteSudo runs commands as root with. When empty, sudo is used.
+	Elevation Elevation
+
+	// Dialer establishes the network connection to the host, or to the first jump host, before
+	// the SSH handshake. When nil, a net.Dialer is used.
+	Dialer DialFunc
+
+	// BannerFunc receives the banner the server sends before authentication. Returning an
+	// error aborts the connection.
+	BannerFunc func(message string) error
+
+	// Ciphers lists the allowed cipher algorithms. When empty, the defaults of
+	// golang.org/x/crypto/ssh are used.
+	Ciphers []string
+	// KeyExchanges lists the allowed key exchange algorithms. When empty, the defaults of
+	// golang.org/x/crypto/ssh are used.
+	KeyExchanges []string
+	// MACs lists the allowed MAC algorithms. When empty, the defaults of
+	// golang.org/x/crypto/ssh are used.
+	MACs []string
+	// HostKeyAlgorithms lists the accepted host key algorithms, in order of preference. When
+	// empty, the defaults of golang.org/x/crypto/ssh are used.
+	HostKeyAlgorithms []string
+}
+
+// DefaultDialTimeout is the dial timeout used when none is configured.
+const DefaultDialTimeout = 30 * time.Second
+
+// WithDialTimeout fails the connection to a host when it is not established within d.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.DialTimeout = d
+	}
+}
+
+func (o *Options) dialTimeout() time.Duration {
+	switch {
+	case o.DialTimeout < 0:
+		return 0
+	case o.DialTimeout > 0:
+		return o.DialTimeout
+	case o.Timeout != 0:
+		return o.Timeout
+	}
+	return DefaultDialTimeout
+}
+
+// DialFunc connects to address on the named network, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network string, address string) (net.Conn, error)
+
+// WithDialer establishes connections with dial instead of a net.Dialer, e.g. to connect
+// through a SOCKS proxy. The dial timeout is applied through the context passed to it.
+func WithDialer(dial DialFunc) Option {
+	return func(o *Options) {
+		o.Dialer = dial
+	}
+}
+
+// WithBannerFunc passes the login banner of the server to fn, e.g. to log it or to refuse
+// hosts presenting an unexpected banner by returning an error.
+func WithBannerFunc(fn func(message string) error) Option {
+	return func(o *Options) {
+		o.BannerFunc = fn
+	}
+}
+
+// WithKnownHosts verifies the remote host key against the given known_hosts file.
+// Unknown, mismatched or revoked host keys make the connection fail.
+func WithKnownHosts(path string) Option {
+	return func(o *Options) {
+		o.KnownHostsFile = path
+	}
+}
+
+func newOptions(opts []Option) *Options {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+func (o *Options) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if o.HostKeyCallback != nil {
+		return o.HostKeyCallback, nil
+	}
+	if o.ExpectedFingerprint != "" {
+		return fingerprintCallback(o.ExpectedFingerprint)
+	}
+	if o.KnownHostsFile != "" && o.TrustOnFirstUse {
+		return tofuCallback(o.KnownHostsFile), nil
+	}
+	if o.KnownHostsFile != "" {
+		return knownHostsCallback(o.KnownHostsFile)
+	}
+	return ssh.InsecureIgnoreHostKey(), nil
+}
+
+func (o *Options) clientConfig(user string, auth []ssh.AuthMethod) (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := o.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	if o.OnHostKey != nil {
+		hostKeyCallback = observedCallback(hostKeyCallback, o.OnHostKey)
+	}
+
+	clientVersion, err := o.clientVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         o.dialTimeout(),
+		ClientVersion:   clientVersion,
+	}
+	config.BannerCallback = o.BannerFunc
+	config.Ciphers = o.Ciphers
+	config.KeyExchanges = o.KeyExchanges
+	config.MACs = o.MACs
+	if len(o.HostKeyAlgorithms) > 0 {
+		config.HostKeyAlgorithms = o.HostKeyAlgorithms
+	}
+
+	return config, nil
+}
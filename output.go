@@ -0,0 +1,35 @@
+package operator
+
+import (
+	"bytes"
+)
+
+// WithMaxOutputBytes limits how much of the standard output and of the standard error of a
+// command is kept in its CommandRes, so a command writing gigabytes cannot exhaust the memory.
+// Each stream keeps its first limit bytes and drops the rest, which sets Truncated; the merged
+// output of ExecuteCombined is cut off the same way. Output
+// copied to the terminal or to the writers passed to ExecuteStream is not affected. A limit
+// of zero, the default, means no limit.
+func WithMaxOutputBytes(limit int64) Option {
+	return func(o *Options) {
+		o.MaxOutputBytes = limit
+	}
+}
+
+// outputBuffer collects the output of a command, keeping at most limit bytes when limit is positive.
+type outputBuffer struct {
+	bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 {
+		if room := b.limit - int64(b.Len()); int64(len(p)) > room {
+			b.truncated = true
+			b.Buffer.Write(p[:room])
+			return len(p), nil
+		}
+	}
+	return b.Buffer.Write(p)
+}
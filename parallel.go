@@ -0,0 +1,85 @@
+package operator
+
+import (
+	"context"
+	"sync"
+)
+
+// Target is a host to run a callback against with ExecuteParallel. The jump hosts listed
+// in Jumps, if any, are used to reach it.
+type Target struct {
+	HostSpec
+}
+
+// Result is the outcome of running a callback against a single Target.
+type Result struct {
+	Target Target
+	// Err is the error returned by the connection or the callback, nil on success.
+	Err error
+}
+
+// WithResultHandler calls handler with the Result of each target as soon as it is done,
+// to report progress during ExecuteParallel. Calls to handler are serialized.
+func WithResultHandler(handler func(Result)) Option {
+	return func(o *Options) {
+		o.ResultHandler = handler
+	}
+}
+
+// ExecuteParallel connects to all targets, at most concurrency at a time, and executes the
+// callback against each of them. A failing target does not stop the others: every Result
+// holds the outcome of its own target, in the same order as targets. A concurrency of zero
+// or less runs all targets at once.
+func ExecuteParallel(targets []Target, concurrency int, callback Callback, opts ...Option) []Result {
+	return ExecuteParallelContext(context.Background(), targets, concurrency, callback, opts...)
+}
+
+func ExecuteParallelContext(ctx context.Context, targets []Target, concurrency int, callback Callback, opts ...Option) []Result {
+	options := newOptions(opts)
+
+	if concurrency <= 0 || concurrency > len(targets) {
+		concurrency = len(targets)
+	}
+
+	results := make([]Result, len(targets))
+	indexes := make(chan int)
+	handlerMutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				target := targets[index]
+				result := Result{Target: target, Err: executeTarget(ctx, target, callback, options)}
+				results[index] = result
+
+				if options.ResultHandler != nil {
+					handlerMutex.Lock()
+					options.ResultHandler(result)
+					handlerMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range targets {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+func executeTarget(ctx context.Context, target Target, callback Callback, options *Options) error {
+	operator, err := dialVia(ctx, target.Jumps, target.HostSpec, options)
+	if err != nil {
+		return err
+	}
+
+	defer operator.Close()
+
+	return callback(operator)
+}
@@ -0,0 +1,72 @@
+package operator
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+)
+
+// PassphraseFunc returns the passphrase of the private key at keyPath.
+type PassphraseFunc func(keyPath string) ([]byte, error)
+
+// WithPassphrase decrypts passphrase-protected private keys with passphrase, e.g. one read
+// from an environment variable in a CI pipeline.
+func WithPassphrase(passphrase string) Option {
+	return WithPassphraseFunc(func(string) ([]byte, error) {
+		return []byte(passphrase), nil
+	})
+}
+
+// WithPassphraseFunc asks fn for the passphrase of passphrase-protected private keys, instead
+// of prompting for it on the terminal.
+func WithPassphraseFunc(fn PassphraseFunc) Option {
+	return func(o *Options) {
+		o.PassphraseFunc = fn
+	}
+}
+
+// WithPassphraseEnv reads the passphrase of passphrase-protected private keys from the
+// environment variable name, e.g. SSH_KEY_PASSPHRASE set from a secret of a CI pipeline.
+// A passphrase set with WithPassphrase or WithPassphraseFunc takes precedence.
+func WithPassphraseEnv(name string) Option {
+	return func(o *Options) {
+		o.PassphraseEnv = name
+	}
+}
+
+// suppliedPassphrase returns the passphrase for the private key at keyPath from the configured
+// PassphraseFunc or PassphraseEnv variable, and whether one of them supplied it.
+func (o *Options) suppliedPassphrase(keyPath string) ([]byte, bool, error) {
+	if o.PassphraseFunc != nil {
+		passphrase, err := o.PassphraseFunc(keyPath)
+		return passphrase, true, err
+	}
+
+	if o.PassphraseEnv != "" {
+		if passphrase := os.Getenv(o.PassphraseEnv); passphrase != "" {
+			return []byte(passphrase), true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// promptPassphrase prompts for the passphrase of the private key at keyPath when stdin is a
+// terminal and prompts are not disabled.
+func (o *Options) promptPassphrase(keyPath string) ([]byte, error) {
+	if o.DisableInteractivePrompts {
+		return nil, errors.Errorf("private key %s is passphrase-protected and interactive prompts are disabled", keyPath)
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(stdin) {
+		return nil, errors.Errorf("private key %s is passphrase-protected and no passphrase source is available", keyPath)
+	}
+
+	fmt.Printf("Enter passphrase for '%s': ", keyPath)
+	passphrase, err := terminal.ReadPassword(stdin)
+	fmt.Println()
+
+	return passphrase, err
+}
@@ -0,0 +1,71 @@
+package operator
+
+import (
+	"io"
+	"time"
+)
+
+// ProgressFunc receives the number of bytes transferred so far, and the total number of
+// bytes to transfer, which is -1 when unknown.
+type ProgressFunc func(transferred int64, total int64)
+
+// progressInterval is the minimum time between two calls of a ProgressFunc.
+const progressInterval = 200 * time.Millisecond
+
+// withProgress wraps r so reading from it reports to progress. It returns r itself when
+// progress is nil.
+func withProgress(r io.Reader, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &progressReader{r: r, total: total, progress: progress}
+}
+
+// progressWriter reports like progressReader, for the bytes written to w with an unknown
+// total. A nil progress is not called. As writing has no end, finish reports the last call.
+type progressWriter struct {
+	w           io.Writer
+	progress    ProgressFunc
+	transferred int64
+	reported    time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.transferred += int64(n)
+
+	if p.progress != nil && (err != nil || time.Since(p.reported) >= progressInterval) {
+		p.progress(p.transferred, -1)
+		p.reported = time.Now()
+	}
+
+	return n, err
+}
+
+func (p *progressWriter) finish() {
+	if p.progress != nil {
+		p.progress(p.transferred, -1)
+	}
+}
+
+// progressReader reports at most every progressInterval, and once more when the end
+// of r is reached or reading from it fails.
+type progressReader struct {
+	r           io.Reader
+	total       int64
+	progress    ProgressFunc
+	transferred int64
+	reported    time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.transferred += int64(n)
+
+	if err != nil || time.Since(p.reported) >= progressInterval {
+		p.progress(p.transferred, p.total)
+		p.reported = time.Now()
+	}
+
+	return n, err
+}
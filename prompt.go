@@ -0,0 +1,70 @@
+package operator
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strings"
+)
+
+// WithoutInteractivePrompts never prompts on the terminal, e.g. when it is used to render a
+// user interface. Passphrases then have to be supplied with WithPassphrase or
+// WithPassphraseFunc, and keyboard-interactive authentication needs a challenge.
+func WithoutInteractivePrompts() Option {
+	return func(o *Options) {
+		o.DisableInteractivePrompts = true
+	}
+}
+
+// TerminalChallenge is an ssh.KeyboardInteractiveChallenge that asks every question of the
+// challenge on the terminal. Answers to questions that should not be echoed, like passwords
+// or one-time codes, are read without echo.
+func TerminalChallenge(user string, instruction string, questions []string, echos []bool) ([]string, error) {
+	if instruction != "" {
+		fmt.Println(instruction)
+	}
+
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		fmt.Print(question)
+
+		if echos[i] {
+			answer, err := readLine(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		} else {
+			answer, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = string(answer)
+		}
+	}
+
+	return answers, nil
+}
+
+// readLine reads a single line from f without buffering past the newline.
+func readLine(f *os.File) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := f.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
@@ -0,0 +1,58 @@
+package operator
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// WithRetry retries establishing the connection up to attempts times in total, waiting
+// interval between attempts. This is useful for freshly booted machines whose SSH daemon
+// is not up yet. Only connection-level failures, like a refused or reset connection, are
+// retried; authentication and host key failures are returned immediately.
+func WithRetry(attempts int, interval time.Duration) Option {
+	return func(o *Options) {
+		o.RetryAttempts = attempts
+		o.RetryInterval = interval
+	}
+}
+
+func (o *Options) retry(ctx context.Context, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	for attempt := 1; ; attempt++ {
+		client, err := dial()
+		if err == nil || attempt >= o.RetryAttempts || !isConnectionError(err) {
+			if err != nil && attempt > 1 {
+				return nil, errors.Wrapf(err, "giving up after %d attempts", attempt)
+			}
+			return client, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(o.RetryInterval):
+		}
+	}
+}
+
+// isConnectionError reports whether err was caused by the network connection rather than
+// by the SSH protocol, e.g. a refused connection or a server hanging up during the handshake.
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) {
+		return channelErr.Reason == ssh.ConnectionFailed
+	}
+
+	// the ssh package reports handshake failures as plain strings
+	message := err.Error()
+	return strings.HasSuffix(message, io.EOF.Error()) || strings.Contains(message, "connection reset by peer")
+}
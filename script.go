@@ -0,0 +1,93 @@
+package operator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"path"
+	"strings"
+)
+
+// DefaultScriptPreamble is prepended to scripts run with ExecuteScript. It stops the script at
+// the first failing command, including failures inside a pipeline and unset variables.
+const DefaultScriptPreamble = "set -euo pipefail"
+
+// WithRemoteTempDir sets the directory scripts run with ExecuteScript are written to, e.g.
+// /var/tmp when /tmp is mounted noexec or is too small. It defaults to /tmp on remote hosts
+// and to os.TempDir for the LocalOperator. Atomic uploads are not affected: their temporary
+// file is always created next to the destination, so it can be renamed into place.
+func WithRemoteTempDir(dir string) Option {
+	return func(o *Options) {
+		o.RemoteTempDir = dir
+	}
+}
+
+// tempDir returns RemoteTempDir, or fallback when it is not set.
+func (o *Options) tempDir(fallback string) string {
+	if o.RemoteTempDir != "" {
+		return o.RemoteTempDir
+	}
+	return fallback
+}
+
+// ScriptOptions configures ExecuteScriptWithOptions.
+type ScriptOptions struct {
+	// Interpreter is the program the script is passed to, e.g. "sh" or "python3". When empty,
+	// the script is run with bash.
+	Interpreter string
+	// Preamble is prepended to the script. When empty, DefaultScriptPreamble is used for bash,
+	// and scripts for other interpreters run unchanged. NoPreamble runs a bash script unchanged too.
+	Preamble   string
+	NoPreamble bool
+}
+
+func (o ScriptOptions) content(script string) []byte {
+	if o.NoPreamble {
+		return []byte(script)
+	}
+
+	preamble := o.Preamble
+	if preamble == "" {
+		if !o.bash() {
+			return []byte(script)
+		}
+		preamble = DefaultScriptPreamble
+	}
+	return []byte(preamble + "\n" + script)
+}
+
+// bash reports whether the script is run with bash, the only interpreter DefaultScriptPreamble
+// is valid for.
+func (o ScriptOptions) bash() bool {
+	fields := strings.Fields(o.Interpreter)
+	return len(fields) == 0 || path.Base(fields[0]) == "bash"
+}
+
+func (o ScriptOptions) command(scriptPath string) string {
+	interpreter := o.Interpreter
+	if interpreter == "" {
+		interpreter = "bash"
+	}
+	return interpreter + " " + shellQuote(scriptPath)
+}
+
+// executeScript writes script to a temporary file in dir with op, runs it, and removes the
+// file again.
+func executeScript(op CommandOperator, dir string, script string, opts ScriptOptions) (CommandRes, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return CommandRes{}, errors.Wrap(err, "unable to generate a script name")
+	}
+	scriptPath := path.Join(dir, "operator-script-"+hex.EncodeToString(suffix))
+
+	if err := op.WriteFile(scriptPath, opts.content(script), "0600"); err != nil {
+		return CommandRes{}, errors.Wrap(err, "unable to upload script")
+	}
+	defer func() {
+		if err := op.Remove(scriptPath); err != nil {
+			warnf("unable to remove script %s: %s", scriptPath, err)
+		}
+	}()
+
+	return op.Execute(opts.command(scriptPath))
+}
@@ -0,0 +1,226 @@
+package operator
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxSessions is the number of sessions an SSHOperator opens at the same time when no
+// limit is configured, which is the default MaxSessions of OpenSSH.
+const DefaultMaxSessions = 10
+
+// WithMaxSessions limits the number of sessions an SSHOperator opens at the same time on its
+// connection. Commands and transfers beyond the limit wait for a running one to finish, rather
+// than being refused by the server. One of the sessions is reserved for the SFTP session the
+// file methods share, which stays open, so a max below 2 is raised to 2. A negative max
+// disables the limit.
+func WithMaxSessions(max int) Option {
+	return func(o *Options) {
+		o.MaxSessions = max
+	}
+}
+
+// sessionLimit holds a token for every open session other than the SFTP session, which has
+// a slot of its own. It is shared by all copies of an SSHOperator; a nil sessionLimit does
+// not limit the sessions.
+type sessionLimit chan struct{}
+
+func newSessionLimit(max int) sessionLimit {
+	if max < 0 {
+		return nil
+	}
+	if max == 0 {
+		max = DefaultMaxSessions
+	}
+	if max < 2 {
+		max = 2
+	}
+	return make(sessionLimit, max-1)
+}
+
+// acquire waits until a session may be opened, or until ctx is done. The returned function
+// releases the session again.
+func (l sessionLimit) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, nil
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "interrupted while waiting for a session")
+	}
+}
+
+// newSession opens a session within the session limit. release must be called once the
+// session is closed.
+func (s SSHOperator) newSession() (sess *ssh.Session, release func(), err error) {
+	release, err = s.sessions.acquire(s.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sess, err = s.conn.NewSession()
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return sess, release, nil
+}
+
+// SFTPClient returns the SFTP client which the file methods of the operator and its copies
+// share, starting it on first use. It is safe for concurrent use and closed with the
+// operator, so the caller must not close it.
+func (s SSHOperator) SFTPClient() (*sftp.Client, error) {
+	return s.sftpClient()
+}
+
+// DefaultSFTPConcurrentRequests is the number of requests in flight per file used by
+// WithSFTPConcurrency when none is given, which is the default of github.com/pkg/sftp.
+const DefaultSFTPConcurrentRequests = 64
+
+// WithSFTPConcurrency uploads files with up to requests writes in flight, rather than waiting
+// for every write to be acknowledged before sending the next one, which speeds up uploads over
+// links with a high latency considerably. As the writes may complete out of order, an
+// interrupted upload which is not atomic can leave gaps in the remote file, so its size is no
+// offset to resume it from. Zero selects DefaultSFTPConcurrentRequests.
+func WithSFTPConcurrency(requests int) Option {
+	return func(o *Options) {
+		if requests <= 0 {
+			requests = DefaultSFTPConcurrentRequests
+		}
+		o.SFTPConcurrentWrites = true
+		o.SFTPMaxConcurrentRequests = requests
+	}
+}
+
+// WithSFTPSubsystem starts SFTP sessions with the given subsystem instead of "sftp". A name
+// containing a slash is the path of an SFTP server program which is executed instead, e.g.
+// /usr/libexec/sftp-server on hosts where the subsystem is not configured.
+func WithSFTPSubsystem(subsystem string) Option {
+	return func(o *Options) {
+		o.SFTPSubsystem = subsystem
+	}
+}
+
+// WithSCPPath runs the remote scp program at path, or a wrapper around it, when uploads fall
+// back to scp.
+func WithSCPPath(path string) Option {
+	return func(o *Options) {
+		o.SCPPath = path
+	}
+}
+
+func (o *Options) sftpClientOptions() []sftp.ClientOption {
+	var opts []sftp.ClientOption
+	if o.SFTPMaxConcurrentRequests > 0 {
+		opts = append(opts, sftp.MaxConcurrentRequestsPerFile(o.SFTPMaxConcurrentRequests))
+	}
+	if o.SFTPConcurrentWrites {
+		opts = append(opts, sftp.UseConcurrentWrites(true))
+	}
+	return opts
+}
+
+// sharedSFTP holds the SFTP client of an SSHOperator. The client occupies the session slot
+// reserved for it by the session limit while it is open, and is started again when its session ended, e.g.
+// because an interrupted transfer closed it.
+type sharedSFTP struct {
+	mu     sync.Mutex
+	client *sftp.Client
+	closed bool
+}
+
+func (s SSHOperator) sftpClient() (*sftp.Client, error) {
+	s.sftp.mu.Lock()
+	defer s.sftp.mu.Unlock()
+
+	if s.sftp.closed {
+		return nil, errors.New("unable to start sftp session: the operator is closed")
+	}
+	if s.sftp.client != nil {
+		return s.sftp.client, nil
+	}
+
+	client, sess, err := s.startSFTP()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start sftp session")
+	}
+	s.sftp.client = client
+
+	go func() {
+		client.Wait()
+		sess.Close()
+
+		s.sftp.mu.Lock()
+		if s.sftp.client == client {
+			s.sftp.client = nil
+		}
+		s.sftp.mu.Unlock()
+	}()
+
+	return client, nil
+}
+
+// ErrSFTPUnavailable is returned by the file methods of an SSHOperator when the server does not
+// offer SFTP, e.g. a git-only or restricted shell. Uploads then fall back to scp, and downloads
+// and ReadFile to cat; the other file methods fail with an error matching it with errors.Is.
+var ErrSFTPUnavailable = errors.New("sftp is not available")
+
+// startSFTP starts an SFTP client on a new session, with the subsystem or server program
+// configured with WithSFTPSubsystem.
+func (s SSHOperator) startSFTP() (*sftp.Client, *ssh.Session, error) {
+	sess, err := s.conn.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, nil, err
+	}
+
+	subsystem := s.options.SFTPSubsystem
+	if subsystem == "" {
+		subsystem = "sftp"
+	}
+	started := "subsystem " + subsystem
+	if strings.Contains(subsystem, "/") {
+		started = subsystem
+		err = sess.Start(subsystem)
+	} else {
+		err = sess.RequestSubsystem(subsystem)
+	}
+	if err == nil {
+		var client *sftp.Client
+		client, err = sftp.NewClientPipe(stdout, stdin, s.options.sftpClientOptions()...)
+		if err == nil {
+			return client, sess, nil
+		}
+	}
+
+	sess.Close()
+	return nil, nil, errors.Wrapf(ErrSFTPUnavailable, "%s: %s", started, err)
+}
+
+// close closes the client, and keeps it from being started again.
+func (c *sharedSFTP) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.client != nil {
+		c.client.Close()
+	}
+}
@@ -0,0 +1,110 @@
+package operator_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/jsiebens/operator"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// latencyConn delays everything read from the wrapped connection by latency, like a link
+// with that round-trip time, without limiting its throughput.
+type latencyConn struct {
+	net.Conn
+	latency time.Duration
+	chunks  chan latencyChunk
+	pending []byte
+	err     error
+}
+
+type latencyChunk struct {
+	data     []byte
+	received time.Time
+	err      error
+}
+
+func newLatencyConn(conn net.Conn, latency time.Duration) *latencyConn {
+	c := &latencyConn{Conn: conn, latency: latency, chunks: make(chan latencyChunk, 1024)}
+	go func() {
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := conn.Read(buf)
+			c.chunks <- latencyChunk{data: buf[:n], received: time.Now(), err: err}
+			if err != nil {
+				close(c.chunks)
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *latencyConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		chunk, ok := <-c.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		time.Sleep(time.Until(chunk.received.Add(c.latency)))
+		c.pending, c.err = chunk.data, chunk.err
+		if len(c.pending) == 0 {
+			return 0, c.err
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// BenchmarkUploadLatency uploads 1 MiB over a link with a round-trip time of 10ms, with
+// the writes acknowledged one by one and with WithSFTPConcurrency.
+func BenchmarkUploadLatency(b *testing.B) {
+	dialer := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return newLatencyConn(conn, 10*time.Millisecond), nil
+	}
+
+	dir, err := ioutil.TempDir("", "operator-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 1024*1024)
+
+	for _, bench := range []struct {
+		name string
+		opts []operator.Option
+	}{
+		{"sequential", []operator.Option{operator.WithDialer(dialer)}},
+		{"concurrent", []operator.Option{operator.WithDialer(dialer), operator.WithSFTPConcurrency(0)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			op, stop := dialTestServer(b, bench.opts...)
+			defer stop()
+
+			remotePath := filepath.Join(dir, bench.name)
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := op.UploadWithOptions(bytes.NewReader(data), remotePath, operator.UploadOptions{Mode: "0644"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func (s SSHOperator) Stat(remotePath string) (os.FileInfo, error) {
+	client, err := s.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.Stat(s.options.Shell.sftpPath(remotePath))
+	if err != nil {
+		return nil, remotePathError("stat", remotePath, err)
+	}
+
+	return info, nil
+}
+
+func (s SSHOperator) Exists(remotePath string) (bool, error) {
+	return exists(s.Stat(remotePath))
+}
+
+func (s SSHOperator) IsDir(remotePath string) (bool, error) {
+	return isDir(s.Stat(remotePath))
+}
+
+func (s SSHOperator) Mkdir(remotePath string, mode string) error {
+	permissions, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	return sftpMkdir(client, s.options.Shell.sftpPath(remotePath), permissions)
+}
+
+func (s SSHOperator) MkdirAll(remotePath string, mode string) error {
+	permissions, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	stat := func(p string) (os.FileInfo, error) {
+		info, err := client.Stat(p)
+		if err != nil {
+			return nil, remotePathError("stat", p, err)
+		}
+		return info, nil
+	}
+	mkdir := func(p string) error {
+		return sftpMkdir(client, p, permissions)
+	}
+
+	return mkdirAll(s.options.Shell.sftpPath(remotePath), stat, mkdir, path.Dir)
+}
+
+func (s SSHOperator) Remove(remotePath string) error {
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.Remove(s.options.Shell.sftpPath(remotePath)); err != nil {
+		return remotePathError("remove", remotePath, err)
+	}
+	return nil
+}
+
+func (s SSHOperator) Rename(oldPath string, newPath string) error {
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	oldPath = s.options.Shell.sftpPath(oldPath)
+	if _, err := client.Lstat(oldPath); err != nil {
+		return remotePathError("rename", oldPath, err)
+	}
+
+	return sftpRename(client, oldPath, s.options.Shell.sftpPath(newPath))
+}
+
+func (s SSHOperator) Chmod(remotePath string, mode os.FileMode) error {
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.Chmod(s.options.Shell.sftpPath(remotePath), mode); err != nil {
+		return remotePathError("chmod", remotePath, err)
+	}
+	return nil
+}
+
+func (s SSHOperator) Chown(remotePath string, owner string, group string) error {
+	return UploadOptions{Owner: owner, Group: group}.chown(s.elevator(), remotePath)
+}
+
+// list returns everything below remoteDir by relative path, without following symbolic
+// links. A missing remoteDir is empty.
+func (s SSHOperator) list(remoteDir string) (map[string]os.FileInfo, error) {
+	client, err := s.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	root := path.Clean(s.options.Shell.sftpPath(remoteDir))
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	listed := map[string]os.FileInfo{}
+
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == root && errors.Is(err, os.ErrNotExist) {
+				return listed, nil
+			}
+			return nil, remotePathError("stat", walker.Path(), err)
+		}
+		if walker.Path() != root {
+			listed[strings.TrimPrefix(walker.Path(), prefix)] = walker.Stat()
+		}
+	}
+
+	return listed, nil
+}
+
+// sftpMkdir creates dir with the given permissions. SFTP servers report an existing path as
+// a generic failure, so it is checked for explicitly to report os.ErrExist.
+func sftpMkdir(client *sftp.Client, dir string, mode os.FileMode) error {
+	if err := client.Mkdir(dir); err != nil {
+		if _, statErr := client.Lstat(dir); statErr == nil {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		return remotePathError("mkdir", dir, err)
+	}
+	if err := client.Chmod(dir, mode); err != nil {
+		return remotePathError("chmod", dir, err)
+	}
+	return nil
+}
+
+// sftpMkdirAll creates dir and its missing parents with mode, or with the defaults of the
+// server when mode is zero. A directory created concurrently by another upload is no error.
+func sftpMkdirAll(client *sftp.Client, dir string, mode os.FileMode) error {
+	if mode == 0 {
+		if err := client.MkdirAll(dir); err != nil {
+			return remotePathError("mkdir", dir, err)
+		}
+		return nil
+	}
+
+	stat := func(p string) (os.FileInfo, error) {
+		info, err := client.Stat(p)
+		if err != nil {
+			return nil, remotePathError("stat", p, err)
+		}
+		return info, nil
+	}
+	mkdir := func(p string) error {
+		if err := sftpMkdir(client, p, mode); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return mkdirAll(dir, stat, mkdir, path.Dir)
+}
+
+// sftpWriteFile writes source to remotePath. With parents, missing parent directories are
+// created with dirMode, or with the defaults of the server when it is zero. When atomic is set, the data is written to a temporary file next to remotePath, which is renamed into
+// place once the transfer succeeded and removed otherwise. A non-zero modTime is applied to
+// the uploaded file.
+func sftpWriteFile(client *sftp.Client, source io.Reader, remotePath string, mode os.FileMode, dirMode os.FileMode, parents bool, modTime time.Time, atomic bool) error {
+	dir := path.Dir(remotePath)
+	if parents {
+		if err := sftpMkdirAll(client, dir, dirMode); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := remotePath
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if atomic {
+		name, err := tempName(path.Base(remotePath))
+		if err != nil {
+			return err
+		}
+		tmpPath = path.Join(dir, name)
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+
+	destination, err := client.OpenFile(tmpPath, flags)
+	if err != nil {
+		return remotePathError("open", tmpPath, err)
+	}
+
+	// set before writing, so the data is never readable with the permissions of a new file
+	err = destination.Chmod(mode)
+	if err == nil {
+		// a reader of unknown size is written concurrently only when it claims to be unlimited,
+		// like *sftp.File presumes for an *os.File
+		_, err = destination.ReadFrom(&io.LimitedReader{R: source, N: math.MaxInt64})
+	}
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = client.Chtimes(tmpPath, modTime, modTime)
+	}
+	if err == nil && atomic {
+		err = sftpRename(client, tmpPath, remotePath)
+	}
+
+	if err != nil {
+		// checked before the temporary file is removed, which frees the space again
+		err = sftpDiskFull(client, dir, remotePath, err)
+		if atomic {
+			client.Remove(tmpPath)
+		}
+	}
+
+	return err
+}
+
+// sftpResumeFile writes source to the existing remotePath from offset on.
+func sftpResumeFile(client *sftp.Client, source io.Reader, remotePath string, mode os.FileMode, offset int64) error {
+	destination, err := client.OpenFile(remotePath, os.O_WRONLY)
+	if err != nil {
+		return remotePathError("open", remotePath, err)
+	}
+
+	err = resumeFile(destination, source, remotePath, mode, offset)
+	if err != nil {
+		err = sftpDiskFull(client, path.Dir(remotePath), remotePath, err)
+	}
+	return err
+}
+
+// sftpDiskFull reports err as a DiskFullError for remotePath when the file system of dir is
+// full. OpenSSH reports a full disk as a generic failure, so after such a failure the free
+// space is checked with the statvfs extension, when the server has it.
+func sftpDiskFull(client *sftp.Client, dir string, remotePath string, err error) error {
+	if isDiskFull(err) {
+		return &DiskFullError{Path: remotePath, Err: err}
+	}
+
+	var status *sftp.StatusError
+	if !errors.As(err, &status) || status.FxCode() != sftp.ErrSSHFxFailure {
+		return err
+	}
+	if _, ok := client.HasExtension("statvfs@openssh.com"); !ok {
+		return err
+	}
+
+	if stat, statErr := client.StatVFS(dir); statErr == nil && stat.Bavail == 0 {
+		return &DiskFullError{Path: remotePath, Err: err}
+	}
+	return err
+}
+
+// sftpRename renames oldPath to newPath, replacing newPath if it exists. Plain SFTP renames
+// refuse to overwrite, so the posix-rename extension is used when the server has it. Only
+// servers without it get newPath removed before the rename.
+func sftpRename(client *sftp.Client, oldPath string, newPath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldPath, newPath)
+	}
+
+	if err := client.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return remotePathError("remove", newPath, err)
+	}
+	if err := client.Rename(oldPath, newPath); err != nil {
+		return remotePathError("rename", newPath, err)
+	}
+	return nil
+}
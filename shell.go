@@ -0,0 +1,212 @@
+package operator
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// Shell selects the shell commands are run with.
+type Shell string
+
+const (
+	// ShellDefault passes remote commands unchanged to the login shell of the remote user,
+	// which is assumed to be a POSIX shell. Local commands are run with /bin/bash -c.
+	ShellDefault Shell = ""
+	// ShellBash runs commands with bash -c.
+	ShellBash Shell = "bash"
+	// ShellSh runs commands with sh -c. On many systems /bin/sh is dash rather than bash,
+	// so bash features like arrays or [[ are not available.
+	ShellSh Shell = "sh"
+	// ShellCmd runs commands with cmd.exe /C, for Windows hosts.
+	ShellCmd Shell = "cmd"
+	// ShellPowerShell runs commands with powershell.exe -NoProfile, for Windows hosts.
+	// Commands are passed base64 encoded, so they need no escaping.
+	ShellPowerShell Shell = "powershell"
+	// ShellExec runs commands without a shell. The command is split into arguments at
+	// whitespace; single quotes preserve everything up to the next single quote, double
+	// quotes preserve everything but backslash escapes of " and \, and a backslash outside
+	// quotes escapes the next character. Nothing is expanded: variables, globs, pipes and
+	// redirections are passed as literal arguments, which makes it safe for untrusted input.
+	// The first argument is the program to run. Remote commands are still started by the
+	// login shell of the user, but with every argument quoted.
+	ShellExec Shell = "exec"
+)
+
+// WithShell runs every command with the given shell. Selecting ShellCmd or ShellPowerShell
+// also makes the operator accept Windows paths with backslashes and drive letters for file transfers.
+func WithShell(shell Shell) Option {
+	return func(o *Options) {
+		o.Shell = shell
+	}
+}
+
+// WithWorkingDir runs every command in dir. When dir does not exist on the remote host, the
+// command is not run and fails with the error of cd.
+func WithWorkingDir(dir string) Option {
+	return func(o *Options) {
+		o.WorkingDir = dir
+	}
+}
+
+func (s Shell) windows() bool {
+	return s == ShellCmd || s == ShellPowerShell
+}
+
+func (s Shell) wrap(command string) string {
+	switch s {
+	case ShellBash, ShellSh:
+		return string(s) + " -c " + shellQuote(command)
+	case ShellCmd:
+		return `cmd.exe /S /C "` + command + `"`
+	case ShellPowerShell:
+		return "powershell.exe -NoProfile -NonInteractive -EncodedCommand " + encodePowerShell(command)
+	}
+	return command
+}
+
+// localCommand returns the program and arguments that run command on the local machine.
+func (s Shell) localCommand(command string) (string, []string, error) {
+	switch s {
+	case ShellSh:
+		return "/bin/sh", []string{"-c", command}, nil
+	case ShellCmd:
+		return "cmd.exe", []string{"/C", command}, nil
+	case ShellPowerShell:
+		return "powershell.exe", []string{"-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(command)}, nil
+	case ShellExec:
+		args, err := splitArgs(command)
+		if err != nil {
+			return "", nil, err
+		}
+		return args[0], args[1:], nil
+	}
+	return "/bin/bash", []string{"-c", command}, nil
+}
+
+// setEnv returns a statement which sets an environment variable for the command that follows it.
+func (s Shell) setEnv(name string, value string) string {
+	switch s {
+	case ShellCmd:
+		return fmt.Sprintf(`set "%s=%s" && `, name, value)
+	case ShellPowerShell:
+		return fmt.Sprintf("$env:%s = %s; ", name, powerShellQuote(value))
+	}
+	return fmt.Sprintf("export %s=%s; ", name, shellQuote(value))
+}
+
+// changeDir returns a statement which changes to dir, and only runs the command that follows
+// it when that succeeded.
+func (s Shell) changeDir(dir string) string {
+	switch s {
+	case ShellCmd:
+		return fmt.Sprintf(`cd /d "%s" && `, dir)
+	case ShellPowerShell:
+		return fmt.Sprintf("Set-Location -LiteralPath %s -ErrorAction Stop; ", powerShellQuote(dir))
+	}
+	return fmt.Sprintf("cd %s && ", shellQuote(dir))
+}
+
+var drivePattern = regexp.MustCompile(`^[A-Za-z]:/`)
+
+// sftpPath converts a Windows path like C:\Users\deploy into the /C:/Users/deploy form
+// understood by the SFTP server of Windows OpenSSH. Other paths are returned unchanged.
+func (s Shell) sftpPath(p string) string {
+	if !s.windows() {
+		return p
+	}
+	p = strings.Replace(p, `\`, "/", -1)
+	if drivePattern.MatchString(p) {
+		p = "/" + p
+	}
+	return p
+}
+
+// splitArgs splits command into arguments as described for ShellExec.
+func splitArgs(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				current.WriteByte(command[i])
+			}
+			inArg = true
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.Errorf("unterminated single quote in command: %s", command)
+			}
+			current.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && (command[i+1] == '"' || command[i+1] == '\\') {
+					i++
+				}
+				current.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, errors.Errorf("unterminated double quote in command: %s", command)
+			}
+			inArg = true
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
+
+// quoteArgs joins args into a POSIX command line which the shell passes to the program unchanged.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s so a POSIX shell treats it as a single word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// powerShellQuote quotes s as a PowerShell string literal.
+func powerShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// encodePowerShell encodes command for powershell -EncodedCommand, which expects UTF-16LE in base64.
+func encodePowerShell(command string) string {
+	units := utf16.Encode([]rune(command))
+	buffer := make([]byte, 2*len(units))
+	for i, unit := range units {
+		binary.LittleEndian.PutUint16(buffer[2*i:], unit)
+	}
+	return base64.StdEncoding.EncodeToString(buffer)
+}
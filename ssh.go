@@ -2,54 +2,499 @@ package operator
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/bramvdbogaerde/go-scp"
+	"github.com/pkg/errors"
 	"io"
+	"net"
 	"os"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// SSHOperator runs commands and transfers files over an SSH connection. It is safe for
+// concurrent use: every command opens its own session on the shared connection, and file
+// transfers share one SFTP client, so several goroutines may call Execute or Upload at once.
+// Servers limit the number of sessions open at the same time on a connection, OpenSSH to 10
+// by default (MaxSessions), so calls beyond DefaultMaxSessions wait for a session to become
+// available; see WithMaxSessions. Copies of an SSHOperator share the connection.
 type SSHOperator struct {
-	conn *ssh.Client
+	ctx       context.Context
+	host      string
+	conn      *ssh.Client
+	jumps     []*ssh.Client
+	options   *Options
+	keepalive *keepalive
+	closed    *closeOnce
+	sessions  sessionLimit
+	sftp      *sharedSFTP
+	timing    Timing
 }
 
-func NewSSHOperator(address string, config *ssh.ClientConfig) (*SSHOperator, error) {
-	conn, err := ssh.Dial("tcp", address, config)
+func NewSSHOperator(address string, config *ssh.ClientConfig, opts ...Option) (*SSHOperator, error) {
+	return NewSSHOperatorContext(context.Background(), address, config, opts...)
+}
+
+// NewSSHOperatorContext connects to address like NewSSHOperator, but aborts the dial and
+// handshake when ctx is done. Commands executed by the returned operator are interrupted
+// as soon as ctx is cancelled or its deadline expires.
+func NewSSHOperatorContext(ctx context.Context, address string, config *ssh.ClientConfig, opts ...Option) (*SSHOperator, error) {
+	options := newOptions(opts)
+
+	var timing Timing
+	conn, err := dialSSH(ctx, address, config, options, &timing)
+	if err != nil {
+		return nil, err
+	}
+
+	operator, err := newSSHOperator(ctx, address, conn, nil, options, false)
+	if err != nil {
+		return nil, err
+	}
+	operator.timing = timing
+
+	return operator, nil
+}
+
+// DialSSH connects to host and returns the underlying client, for what an SSHOperator does
+// not cover, e.g. opening custom channels or sending global requests. Failures are reported
+// as *DialError and *AuthError, like those of Dial. The caller is responsible for closing
+// the client; NewSSHOperatorFromClient runs commands over it.
+func DialSSH(host string, port int, config *ssh.ClientConfig) (*ssh.Client, error) {
+	return DialSSHContext(context.Background(), host, port, config)
+}
+
+func DialSSHContext(ctx context.Context, host string, port int, config *ssh.ClientConfig) (*ssh.Client, error) {
+	return dialSSH(ctx, HostSpec{Host: host, Port: port}.address(), config, newOptions(nil), &Timing{})
+}
+
+// dialSSH connects to address with the dialer, legacy fallback and tracer of options.
+func dialSSH(ctx context.Context, address string, config *ssh.ClientConfig, options *Options, timing *Timing) (*ssh.Client, error) {
+	end := options.trace(ctx, &Span{Name: "Dial", Host: address})
+	conn, err := options.legacyFallback(address, config, func(config *ssh.ClientConfig) (*ssh.Client, error) {
+		return dialContext(ctx, address, config, options.Dialer, timing)
+	})
+	end(err)
+	if err != nil {
+		return nil, connectError(address, config.User, err)
+	}
+	return conn, nil
+}
+
+// NewSSHOperatorConn runs SSH over an already established connection, e.g. one created by a
+// custom dialer or one connected to an in-process SSH server. address is only used for host
+// key verification and error messages. conn is closed with the operator.
+//
+// Both ends of an SSH connection send their version before reading, so conn must buffer
+// writes; an unbuffered net.Pipe deadlocks the handshake.
+func NewSSHOperatorConn(conn net.Conn, address string, config *ssh.ClientConfig, opts ...Option) (*SSHOperator, error) {
+	return NewSSHOperatorConnContext(context.Background(), conn, address, config, opts...)
+}
+
+func NewSSHOperatorConnContext(ctx context.Context, conn net.Conn, address string, config *ssh.ClientConfig, opts ...Option) (*SSHOperator, error) {
+	start := time.Now()
+	client, err := handshake(ctx, conn, address, config)
+	if err != nil {
+		return nil, connectError(address, config.User, err)
+	}
+	timing := Timing{HandshakeDuration: time.Since(start)}
+
+	operator, err := newSSHOperator(ctx, address, client, nil, newOptions(opts), false)
 	if err != nil {
 		return nil, err
 	}
+	operator.timing = timing
+
+	return operator, nil
+}
+
+// NewSSHOperatorFromClient runs commands over an existing client, e.g. one shared with other
+// SSH code of the application. The client stays owned by the caller: Close leaves it open
+// unless WithCloseClient is given, only a keepalive which finds the connection lost closes it.
+func NewSSHOperatorFromClient(client *ssh.Client, opts ...Option) (*SSHOperator, error) {
+	return NewSSHOperatorFromClientContext(context.Background(), client, opts...)
+}
+
+func NewSSHOperatorFromClientContext(ctx context.Context, client *ssh.Client, opts ...Option) (*SSHOperator, error) {
+	options := newOptions(opts)
+	return newSSHOperator(ctx, "", client, nil, options, !options.CloseClient)
+}
+
+// WithCloseClient closes the client passed to NewSSHOperatorFromClient when the operator is closed.
+func WithCloseClient() Option {
+	return func(o *Options) {
+		o.CloseClient = true
+	}
+}
+
+// newSSHOperator creates an operator for an established connection to host, which is the
+// address of conn when empty. On failure, conn and the jump host connections are closed,
+// unless conn is borrowed from the caller.
+func newSSHOperator(ctx context.Context, host string, conn *ssh.Client, jumps []*ssh.Client, options *Options, borrowed bool) (*SSHOperator, error) {
+	if host == "" {
+		host = conn.RemoteAddr().String()
+	}
 
 	operator := SSHOperator{
-		conn: conn,
+		ctx:       ctx,
+		host:      host,
+		conn:      conn,
+		jumps:     jumps,
+		options:   options,
+		keepalive: &keepalive{},
+		closed:    &closeOnce{done: make(chan struct{}), borrowed: borrowed},
+		sessions:  newSessionLimit(options.MaxSessions),
+		sftp:      &sharedSFTP{},
+	}
+
+	if err := operator.forwardAgent(); err != nil {
+		operator.Close()
+		return nil, err
 	}
 
+	operator.startKeepalive()
+
 	return &operator, nil
 }
 
+// dialContext connects to address with dial, or a net.Dialer when dial is nil, and performs
+// the SSH handshake. Both are measured in timing.
+func dialContext(ctx context.Context, address string, config *ssh.ClientConfig, dial DialFunc, timing *Timing) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+
+	start := time.Now()
+
+	if dial == nil {
+		dialer := net.Dialer{Timeout: config.Timeout}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		dialCtx, cancel := ctx, func() {}
+		if config.Timeout > 0 {
+			dialCtx, cancel = context.WithTimeout(ctx, config.Timeout)
+		}
+		conn, err = dial(dialCtx, "tcp", address)
+		cancel()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return timedHandshake(ctx, conn, address, config, timing, start)
+}
+
+// dialThrough connects to address by tunneling through the already established client.
+func dialThrough(ctx context.Context, client *ssh.Client, address string, config *ssh.ClientConfig, timing *Timing) (*ssh.Client, error) {
+	start := time.Now()
+	conn, err := client.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return timedHandshake(ctx, conn, address, config, timing, start)
+}
+
+// timedHandshake performs the handshake over conn, which was dialed at start, and records the
+// durations of both in timing once it succeeded.
+func timedHandshake(ctx context.Context, conn net.Conn, address string, config *ssh.ClientConfig, timing *Timing, start time.Time) (*ssh.Client, error) {
+	dialed := time.Now()
+	client, err := handshake(ctx, conn, address, config)
+	if err != nil {
+		return nil, err
+	}
+
+	timing.DialDuration = dialed.Sub(start)
+	timing.HandshakeDuration = time.Since(dialed)
+
+	return client, nil
+}
+
+func handshake(ctx context.Context, conn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	// The timeout also covers the handshake, so a host that accepts the connection but never answers fails too.
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+
+	stop := closeOnDone(ctx, conn)
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	stop()
+
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// Close closes the underlying SSH connection, and the connections to any jump hosts it
+// was established through. The operator can no longer be used afterwards. Close may be
+// called any number of times, also on copies of the operator; only the first call closes
+// the connections, and every call returns its result.
+//
+// A client passed to NewSSHOperatorFromClient is left open, unless WithCloseClient is given.
 func (s SSHOperator) Close() error {
-	return s.conn.Close()
+	s.closed.once.Do(func() {
+		close(s.closed.done)
+		s.sftp.close()
+		if !s.closed.borrowed {
+			s.closed.err = s.conn.Close()
+		}
+		for i := len(s.jumps) - 1; i >= 0; i-- {
+			s.jumps[i].Close()
+		}
+	})
+	return s.closed.err
+}
+
+// closeOnce is shared by all copies of an SSHOperator, so the connection is closed only once.
+// done is closed with the operator; borrowed is set when the connection is left open then.
+type closeOnce struct {
+	once     sync.Once
+	err      error
+	done     chan struct{}
+	borrowed bool
 }
 
 func (s SSHOperator) Execute(command string) (CommandRes, error) {
-	sess, err := s.conn.NewSession()
+	return s.ExecuteWithStdin(command, nil)
+}
+
+func (s SSHOperator) ExecuteWithStdin(command string, stdin io.Reader) (CommandRes, error) {
+	return s.options.logCommand(s.ctx, s.Host(), command, func() (CommandRes, error) {
+		return s.executeWithStdin(command, stdin)
+	})
+}
+
+func (s SSHOperator) executeWithStdin(command string, stdin io.Reader) (CommandRes, error) {
+	output := outputBuffer{limit: s.options.MaxOutputBytes}
+	errorOutput := outputBuffer{limit: s.options.MaxOutputBytes}
+
+	res, err := s.executeSession(command, stdin, io.MultiWriter(os.Stdout, &output), io.MultiWriter(os.Stderr, &errorOutput), true, nil)
+
+	res.StdErr = errorOutput.Bytes()
+	res.StdOut = output.Bytes()
+	res.Truncated = output.truncated || errorOutput.truncated
+
+	return res, withStderr(err, command, res.StdErr)
+}
+
+func (s SSHOperator) ExecuteAll(commands []string, stopOnError bool) ([]CommandRes, error) {
+	return executeAll(s.Execute, commands, stopOnError)
+}
+
+func (s SSHOperator) ExecuteTimeout(command string, timeout time.Duration) (CommandRes, error) {
+	return s.options.logCommand(s.ctx, s.Host(), command, func() (CommandRes, error) {
+		return executeTimeout(s.ctx, command, timeout, func(ctx context.Context) (CommandRes, error) {
+			operator := s
+			operator.ctx = ctx
+			return operator.executeWithStdin(command, nil)
+		})
+	})
+}
+
+func (s SSHOperator) ExecuteScript(script string) (CommandRes, error) {
+	return s.ExecuteScriptWithOptions(script, ScriptOptions{})
+}
+
+func (s SSHOperator) ExecuteScriptWithOptions(script string, opts ScriptOptions) (CommandRes, error) {
+	return executeScript(s, s.options.tempDir("/tmp"), script, opts)
+}
+
+func (s SSHOperator) ExecuteSudo(command string, password string) (CommandRes, error) {
+	return s.options.logCommand(s.ctx, s.Host(), command, func() (CommandRes, error) {
+		return s.elevator().run(command, password)
+	})
+}
+
+func (s SSHOperator) elevator() elevator {
+	terminal := func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (CommandRes, error) {
+		return s.executeSession(command, stdin, stdout, stderr, false, func(sess *ssh.Session) error {
+			return errors.Wrap(requestPlainPty(sess), "unable to allocate a pseudo-terminal")
+		})
+	}
+	return elevator{elevation: s.options.Elevation, execute: s.execute, terminal: terminal, limit: s.options.MaxOutputBytes}
+}
+
+func (s SSHOperator) ExecuteStream(command string, stdout io.Writer, stderr io.Writer) (CommandRes, error) {
+	return s.options.logCommand(s.ctx, s.Host(), command, func() (CommandRes, error) {
+		return s.executeSession(command, nil, stdout, stderr, true, nil)
+	})
+}
+
+func (s SSHOperator) ExecuteCombined(command string) ([]byte, int, error) {
+	return executeCombined(s.ExecuteStream, command, s.options.MaxOutputBytes)
+}
+
+func (s SSHOperator) ExecuteString(command string) (string, error) {
+	return trimmedOutput(s.Execute(command))
+}
+
+func (s SSHOperator) ExecuteUntil(command string, predicate func(CommandRes) bool, interval time.Duration, deadline time.Time) (CommandRes, error) {
+	return executeUntil(s.ctx, command, predicate, interval, deadline, func(ctx context.Context) (CommandRes, error) {
+		operator := s
+		operator.ctx = ctx
+		return operator.Execute(command)
+	})
+}
+
+// ExecutePTY runs command like Execute, but on a pseudo-terminal of the given terminal type
+// (e.g. "xterm") and size. Use it for programs that refuse to run, or behave differently,
+// without a terminal. Since a terminal has a single output stream, everything the command
+// writes ends up in StdOut.
+func (s SSHOperator) ExecutePTY(command string, term string, height int, width int) (CommandRes, error) {
+	return s.options.logCommand(s.ctx, s.Host(), command, func() (CommandRes, error) {
+		return s.executePTY(command, term, height, width)
+	})
+}
+
+func (s SSHOperator) executePTY(command string, term string, height int, width int) (CommandRes, error) {
+	output := outputBuffer{limit: s.options.MaxOutputBytes}
+	errorOutput := outputBuffer{limit: s.options.MaxOutputBytes}
+
+	requestPty := func(sess *ssh.Session) error {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		return errors.Wrap(sess.RequestPty(term, height, width, modes), "unable to allocate a pseudo-terminal")
+	}
+
+	res, err := s.executeSession(command, nil, io.MultiWriter(os.Stdout, &output), io.MultiWriter(os.Stderr, &errorOutput), true, requestPty)
+
+	res.StdErr = errorOutput.Bytes()
+	res.StdOut = output.Bytes()
+	res.Truncated = output.truncated || errorOutput.truncated
+
+	return res, withStderr(err, command, res.StdErr)
+}
+
+// Tail runs tail on a pseudo-terminal, which merges its error messages into out. The remote
+// tail is killed and hung up when ctx is done.
+func (s SSHOperator) Tail(ctx context.Context, remotePath string, out io.Writer) error {
+	command := tailCommand(remotePath)
+	_, err := s.options.logCommand(s.ctx, s.Host(), command, func() (CommandRes, error) {
+		return CommandRes{}, tail(s.ctx, ctx, func(ctx context.Context) error {
+			operator := s
+			operator.ctx = ctx
+			_, err := operator.executeSession(command, nil, out, nil, false, func(sess *ssh.Session) error {
+				return errors.Wrap(requestPlainPty(sess), "unable to allocate a pseudo-terminal")
+			})
+			return err
+		})
+	})
+	return err
+}
+
+func (s SSHOperator) Ping() error {
+	sess, release, err := s.newSession()
+	if err != nil {
+		if lost := s.keepalive.error(); lost != nil {
+			return lost
+		}
+		return errors.Wrapf(err, "unable to open a session on %s", s.Host())
+	}
+	sess.Close()
+	release()
+	return nil
+}
+
+// ServerVersion returns the version the server sent in the handshake, e.g. "SSH-2.0-OpenSSH_8.9".
+func (s SSHOperator) ServerVersion() string {
+	return string(s.conn.ServerVersion())
+}
+
+// RemoteAddr returns the address of the connected server.
+func (s SSHOperator) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Host returns the host the operator was connected to as the caller named it, like
+// "web1:22", or the alias of a HostSpec returned by ResolveHost. Operators created from a
+// client return its remote address. It is the host reported to the Logger.
+func (s SSHOperator) Host() string {
+	return s.host
+}
+
+// execute runs a command of the operator itself, e.g. to compute a checksum or change the
+// owner of an uploaded file.
+func (s SSHOperator) execute(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (CommandRes, error) {
+	return s.executeSession(command, stdin, stdout, stderr, false, nil)
+}
+
+// executeSession runs command in a new session, calling setup, when not nil, to prepare
+// the session before the command is started. With watchSudo, a command of the caller without
+// stdin is aborted when it waits at a sudo password prompt; the commands the operator runs
+// itself, like cat or chown, are left alone.
+func (s SSHOperator) executeSession(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer, watchSudo bool, setup func(*ssh.Session) error) (CommandRes, error) {
+	sess, release, err := s.newSession()
 	if err != nil {
 		return CommandRes{}, err
 	}
 
+	defer release()
 	defer sess.Close()
 
-	sessStdOut, err := sess.StdoutPipe()
+	line, err := s.options.remoteCommand(sess, command)
 	if err != nil {
 		return CommandRes{}, err
 	}
 
-	output := bytes.Buffer{}
+	if err := s.requestAgentForwarding(sess); err != nil {
+		return CommandRes{}, err
+	}
+
+	if setup != nil {
+		if err := setup(sess); err != nil {
+			return CommandRes{}, err
+		}
+	}
+
+	sess.Stdin = stdin
+
+	sessStdOut, err := sess.StdoutPipe()
+	if err != nil {
+		return CommandRes{}, err
+	}
 
 	wg := sync.WaitGroup{}
 
-	stdOutWriter := io.MultiWriter(os.Stdout, &output)
+	stdOutWriter := writerOrDiscard(stdout)
+	stdErrWriter := writerOrDiscard(stderr)
+
+	// without stdin, a sudo password prompt would wait forever
+	var prompt *sudoPromptWatcher
+	if watchSudo && stdin == nil {
+		prompt = &sudoPromptWatcher{abort: func() {
+			killer{Session: sess}.Close()
+		}}
+		stdOutWriter = prompt.watch(stdOutWriter)
+		stdErrWriter = prompt.watch(stdErrWriter)
+	}
+
 	wg.Add(1)
 	go func() {
 		io.Copy(stdOutWriter, sessStdOut)
@@ -60,54 +505,474 @@ func (s SSHOperator) Execute(command string) (CommandRes, error) {
 		return CommandRes{}, err
 	}
 
-	errorOutput := bytes.Buffer{}
-	stdErrWriter := io.MultiWriter(os.Stderr, &errorOutput)
 	wg.Add(1)
 	go func() {
 		io.Copy(stdErrWriter, sessStderr)
 		wg.Done()
 	}()
 
-	err = sess.Run(command)
+	exited := make(chan struct{})
+	stop := closeOnDone(s.ctx, s.killer(sess, exited))
+	err = sess.Run(line)
+	close(exited)
+	stop()
 
 	wg.Wait()
 
+	aborted := prompt != nil && prompt.stop()
+
 	if err != nil {
+		if s.ctx.Err() != nil {
+			return CommandRes{}, errors.Wrapf(s.ctx.Err(), "command interrupted: %s", command)
+		}
+		if aborted {
+			return CommandRes{}, errors.Wrapf(ErrSudoPasswordRequired, "command aborted: %s", command)
+		}
+		if lost := s.keepalive.error(); lost != nil {
+			return CommandRes{}, lost
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			res := CommandRes{ExitCode: exitErr.ExitStatus()}
+			if exitErr.Signal() != "" {
+				res.ExitCode = -1
+				res.Signal = exitErr.Signal()
+			}
+			return res, &CommandError{Command: command, ExitCode: res.ExitCode, Signal: res.Signal, Err: err}
+		}
+		// e.g. when the process was killed and the server did not report how it ended
+		if _, ok := err.(*ssh.ExitMissingError); ok {
+			return CommandRes{ExitCode: -1}, &CommandError{Command: command, ExitCode: -1, Err: err}
+		}
 		return CommandRes{}, err
 	}
 
-	return CommandRes{
-		StdErr: errorOutput.Bytes(),
-		StdOut: output.Bytes(),
-	}, nil
+	return CommandRes{}, nil
+}
+
+// killer kills the remote command before closing its session, so an interrupted command does
+// not keep running on the remote host. Servers which do not support signals ignore it.
+// With a grace period, the command is first sent SIGINT and then SIGTERM, and is given the
+// grace period after each of them to exit, which closes exited.
+type killer struct {
+	*ssh.Session
+	grace  time.Duration
+	exited <-chan struct{}
 }
 
+func (s SSHOperator) killer(sess *ssh.Session, exited <-chan struct{}) killer {
+	return killer{Session: sess, grace: s.options.InterruptGracePeriod, exited: exited}
+}
+
+func (k killer) Close() error {
+	if k.grace > 0 {
+		for _, signal := range []ssh.Signal{ssh.SIGINT, ssh.SIGTERM} {
+			k.Signal(signal)
+			select {
+			case <-k.exited:
+				return k.Session.Close()
+			case <-time.After(k.grace):
+			}
+		}
+	}
+
+	k.Signal(ssh.SIGKILL)
+	return k.Session.Close()
+}
+
+// Upload writes source to remotePath over SFTP, creating missing parent directories.
+// The data is first written to a temporary file which is renamed to remotePath after a
+// successful transfer, so an interrupted upload never leaves a truncated file behind.
+// When the server has no SFTP subsystem, Upload falls back to scp.
 func (s SSHOperator) Upload(source io.Reader, remotePath string, mode string) error {
-	sess, err := s.conn.NewSession()
+	return s.UploadWithOptions(source, remotePath, UploadOptions{Mode: mode, Atomic: true, MkdirParents: true})
+}
+
+func (s SSHOperator) UploadWithOptions(source io.Reader, remotePath string, opts UploadOptions) error {
+	permissions, err := opts.fileMode(nil)
+	if err != nil {
+		return err
+	}
+	dirMode, err := opts.dirMode()
+	if err != nil {
+		return err
+	}
+
+	err = s.options.logUpload(s.ctx, s.Host(), "", remotePath, withProgress(opts.counted(source), -1, opts.Progress), func(source io.Reader) error {
+		return s.upload(source, remotePath, permissions, dirMode, opts.MkdirParents, time.Time{}, opts.Atomic)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.chown(s.elevator(), remotePath)
+}
+
+func (s SSHOperator) WriteFile(remotePath string, data []byte, mode string) error {
+	return s.Upload(bytes.NewReader(data), remotePath, mode)
+}
+
+func (s SSHOperator) UploadFile(path string, remotePath string, mode string) error {
+	return s.UploadFileWithOptions(path, remotePath, UploadOptions{Mode: mode, Atomic: true, MkdirParents: true})
+}
+
+func (s SSHOperator) UploadFileWithOptions(path string, remotePath string, opts UploadOptions) error {
+	source, info, err := openLocal(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	permissions, err := opts.fileMode(info)
+	if err != nil {
+		return err
+	}
+	dirMode, err := opts.dirMode()
+	if err != nil {
+		return err
+	}
+
+	err = s.options.logUpload(s.ctx, s.Host(), path, remotePath, withProgress(opts.counted(source), info.Size(), opts.Progress), func(source io.Reader) error {
+		return s.upload(source, remotePath, permissions, dirMode, opts.MkdirParents, opts.modTime(info), opts.Atomic)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.chown(s.elevator(), remotePath)
+}
+
+func (s SSHOperator) UploadAt(source io.ReaderAt, size int64, remotePath string, opts UploadOptions) error {
+	reader, err := opts.resumeSource(source, size, remotePath)
+	if err != nil {
+		return err
+	}
+	if opts.Offset == 0 {
+		// progress is reported by reader, which knows the total size
+		opts.Progress = nil
+		return s.UploadWithOptions(reader, remotePath, opts)
+	}
+
+	permissions, err := opts.fileMode(nil)
+	if err != nil {
+		return err
+	}
+
+	err = s.options.logUpload(s.ctx, s.Host(), "", remotePath, reader, func(source io.Reader) error {
+		return s.resume(source, remotePath, permissions, opts.Offset)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opts.chown(s.elevator(), remotePath)
+}
+
+func (s SSHOperator) UploadN(source io.Reader, remotePath string, mode string) (int64, error) {
+	return uploadN(mode, func(opts UploadOptions) error {
+		return s.UploadWithOptions(source, remotePath, opts)
+	})
+}
+
+func (s SSHOperator) UploadFileN(path string, remotePath string, mode string) (int64, error) {
+	return uploadN(mode, func(opts UploadOptions) error {
+		return s.UploadFileWithOptions(path, remotePath, opts)
+	})
+}
+
+func (s SSHOperator) UploadFileVerified(path string, remotePath string, mode string) error {
+	if err := s.UploadFile(path, remotePath, mode); err != nil {
+		return err
+	}
+	return verifyChecksum(path, remotePath, s.remoteSHA256)
+}
+
+// upload writes source to remotePath. With parents, its missing parent directories are created
+// with dirMode, or with the defaults of the server when dirMode is zero.
+func (s SSHOperator) upload(source io.Reader, remotePath string, mode os.FileMode, dirMode os.FileMode, parents bool, modTime time.Time, atomic bool) error {
+	client, err := s.sftpClient()
+	if errors.Is(err, ErrSFTPUnavailable) {
+		warnf("sftp is not available on %s, falling back to scp: %s", s.conn.RemoteAddr(), err)
+		if !modTime.IsZero() {
+			warnf("scp does not preserve the modification time of %s", remotePath)
+		}
+		if atomic {
+			warnf("scp overwrites %s in place, the upload is not atomic", remotePath)
+		}
+		if parents {
+			if err := s.mkdirParents(remotePath, dirMode); err != nil {
+				return err
+			}
+		}
+		return s.uploadSCP(source, remotePath, fmt.Sprintf("%04o", mode&0777))
+	}
+	if err != nil {
+		return err
+	}
+
+	stop := closeOnDone(s.ctx, client)
+	err = sftpWriteFile(client, source, s.options.Shell.sftpPath(remotePath), mode, dirMode, parents, modTime, atomic)
+	stop()
+
+	if err != nil && s.ctx.Err() != nil {
+		return errors.Wrapf(s.ctx.Err(), "upload interrupted: %s", remotePath)
+	}
+
+	return err
+}
+
+// resume writes source to the existing remotePath from offset on. Unlike upload it has no scp
+// fallback, as scp cannot write at an offset.
+func (s SSHOperator) resume(source io.Reader, remotePath string, mode os.FileMode, offset int64) error {
+	client, err := s.sftpClient()
+	if err != nil {
+		return errors.Wrapf(err, "unable to resume the upload of %s", remotePath)
+	}
+
+	stop := closeOnDone(s.ctx, client)
+	err = sftpResumeFile(client, source, s.options.Shell.sftpPath(remotePath), mode, offset)
+	stop()
+
+	if err != nil && s.ctx.Err() != nil {
+		return errors.Wrapf(s.ctx.Err(), "upload interrupted: %s", remotePath)
+	}
+
+	return err
+}
+
+// mkdirParents creates the missing parent directories of remotePath with mkdir -p, for uploads
+// without SFTP. Windows hosts are left alone, as they have no mkdir -p.
+func (s SSHOperator) mkdirParents(remotePath string, dirMode os.FileMode) error {
+	if s.options.Shell.windows() {
+		return nil
+	}
+
+	dir := path.Dir(remotePath)
+	command := "mkdir -p -- " + shellQuote(dir)
+	if dirMode != 0 {
+		command = fmt.Sprintf("mkdir -p -m %04o -- %s", dirMode&0777, shellQuote(dir))
+	}
+
+	stderr := bytes.Buffer{}
+	_, err := s.execute(command, nil, nil, &stderr)
+	if err == nil {
+		return nil
+	}
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return errors.Errorf("unable to create the parent directories of %s: %s", remotePath, message)
+	}
+	return errors.Wrapf(err, "unable to create the parent directories of %s", remotePath)
+}
+
+func (s SSHOperator) uploadSCP(source io.Reader, remotePath string, mode string) error {
+	sess, release, err := s.newSession()
 	if err != nil {
 		return err
 	}
 
+	defer release()
 	defer sess.Close()
 
+	remoteBinary := s.options.SCPPath
+	if remoteBinary == "" {
+		remoteBinary = "scp"
+	}
+
+	stderr := bytes.Buffer{}
+	sess.Stderr = &stderr
+
 	client := scp.Client{
 		Session:      sess,
 		Conn:         s.conn,
 		Timeout:      time.Minute,
-		RemoteBinary: "scp",
+		RemoteBinary: remoteBinary,
 	}
 
+	stop := closeOnDone(s.ctx, sess)
 	err = client.CopyFile(source, remotePath, mode)
+	stop()
+
+	if err != nil && s.ctx.Err() != nil {
+		return errors.Wrapf(s.ctx.Err(), "upload interrupted: %s", remotePath)
+	}
+	if message := strings.TrimSpace(stderr.String()); err != nil && message != "" {
+		err = errors.Wrapf(err, "unable to upload %s with %s: %s", remotePath, remoteBinary, message)
+	} else if err != nil {
+		err = errors.Wrapf(err, "unable to upload %s with %s", remotePath, remoteBinary)
+	}
+
+	return diskFullError(remotePath, err)
+}
+
+func (s SSHOperator) UploadDir(localDir string, remoteDir string, mode string) error {
+	permissions, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	stop := closeOnDone(s.ctx, client)
+	defer stop()
+
+	err = uploadDir(localDir, s.options.Shell.sftpPath(remoteDir), client.MkdirAll, func(path string, remotePath string) error {
+		source, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+
+		return s.options.logUpload(s.ctx, s.Host(), path, remotePath, source, func(source io.Reader) error {
+			return sftpWriteFile(client, source, remotePath, permissions, 0, false, time.Time{}, true)
+		})
+	})
+
+	if err != nil && s.ctx.Err() != nil {
+		return errors.Wrapf(s.ctx.Err(), "upload interrupted: %s", remoteDir)
+	}
 
 	return err
 }
 
-func (s SSHOperator) UploadFile(path string, remotePath string, mode string) error {
-	source, err := os.Open(expandPath(path))
+func (s SSHOperator) Sync(localDir string, remoteDir string, opts SyncOptions) ([]string, error) {
+	return syncDir(s, localDir, remoteDir, opts, s.list, s.remoteSHA256)
+}
+
+func (s SSHOperator) UploadGlob(pattern string, remoteDir string, mode string) ([]string, error) {
+	permissions, err := ParseMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	stop := closeOnDone(s.ctx, client)
+	defer stop()
+
+	uploaded, err := uploadGlob(pattern, remoteDir, func(path string, remotePath string) error {
+		source, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+
+		return s.options.logUpload(s.ctx, s.Host(), path, remotePath, source, func(source io.Reader) error {
+			return sftpWriteFile(client, source, s.options.Shell.sftpPath(remotePath), permissions, 0, true, time.Time{}, true)
+		})
+	})
+
+	if err != nil && s.ctx.Err() != nil {
+		return uploaded, errors.Wrapf(s.ctx.Err(), "upload interrupted: %s", remoteDir)
+	}
+
+	return uploaded, err
+}
+
+func (s SSHOperator) Download(remotePath string, dst io.Writer) error {
+	return s.DownloadWithOptions(remotePath, dst, DownloadOptions{})
+}
+
+func (s SSHOperator) DownloadWithOptions(remotePath string, dst io.Writer, opts DownloadOptions) error {
+	client, err := s.sftpClient()
+	if errors.Is(err, ErrSFTPUnavailable) && !s.options.Shell.windows() {
+		warnf("sftp is not available on %s, falling back to cat: %s", s.conn.RemoteAddr(), err)
+		return s.downloadCat(remotePath, dst, opts)
+	}
 	if err != nil {
 		return err
 	}
+
+	source, err := client.Open(s.options.Shell.sftpPath(remotePath))
+	if err != nil {
+		return remotePathError("open", remotePath, err)
+	}
 	defer source.Close()
 
-	return s.Upload(source, remotePath, mode)
+	info, err := source.Stat()
+	if err != nil {
+		return remotePathError("stat", remotePath, err)
+	}
+	if info.IsDir() {
+		return errors.Errorf("unable to download %s: is a directory", remotePath)
+	}
+
+	stop := closeOnDone(s.ctx, client)
+	_, err = io.Copy(dst, withProgress(source, info.Size(), opts.Progress))
+	stop()
+
+	if err != nil && s.ctx.Err() != nil {
+		return errors.Wrapf(s.ctx.Err(), "download interrupted: %s", remotePath)
+	}
+
+	return err
+}
+
+// downloadCat downloads remotePath by running cat, for servers without SFTP. Session channels
+// carry binary data unchanged, so the output needs no encoding.
+func (s SSHOperator) downloadCat(remotePath string, dst io.Writer, opts DownloadOptions) error {
+	progress := &progressWriter{w: dst, progress: opts.Progress}
+	stdout := &abortingWriter{w: progress}
+	stderr := bytes.Buffer{}
+
+	_, err := s.executeSession("cat -- "+shellQuote(remotePath), nil, stdout, &stderr, false, func(sess *ssh.Session) error {
+		stdout.abort = func() { sess.Close() }
+		return nil
+	})
+	if err == nil {
+		progress.finish()
+		return nil
+	}
+	if s.ctx.Err() != nil {
+		return errors.Wrapf(s.ctx.Err(), "download interrupted: %s", remotePath)
+	}
+	if stdout.err != nil {
+		return stdout.err
+	}
+
+	message := strings.TrimSpace(stderr.String())
+	switch {
+	case strings.Contains(message, "No such file or directory"):
+		return &os.PathError{Op: "open", Path: remotePath, Err: os.ErrNotExist}
+	case strings.Contains(message, "Permission denied"):
+		return &os.PathError{Op: "open", Path: remotePath, Err: os.ErrPermission}
+	case strings.Contains(message, "Is a directory"):
+		return errors.Errorf("unable to download %s: is a directory", remotePath)
+	case message != "":
+		return errors.Errorf("unable to download %s: %s", remotePath, message)
+	}
+	return errors.Wrapf(err, "unable to download %s", remotePath)
+}
+
+// abortingWriter calls abort once writing to w failed, to stop a command whose output is
+// no longer read instead of leaving it blocked.
+type abortingWriter struct {
+	w     io.Writer
+	abort func()
+	err   error
+}
+
+func (a *abortingWriter) Write(b []byte) (int, error) {
+	n, err := a.w.Write(b)
+	if err != nil && a.err == nil {
+		a.err = err
+		a.abort()
+	}
+	return n, err
+}
+
+func (s SSHOperator) DownloadFile(remotePath string, localPath string) error {
+	return s.DownloadFileWithOptions(remotePath, localPath, DownloadOptions{})
+}
+
+func (s SSHOperator) ReadFile(remotePath string) ([]byte, error) {
+	return readFile(s, remotePath, s.options.ReadFileLimit)
+}
+
+func (s SSHOperator) DownloadFileWithOptions(remotePath string, localPath string, opts DownloadOptions) error {
+	return downloadFile(s, remotePath, localPath, opts)
 }
@@ -0,0 +1,104 @@
+package operator_test
+
+import (
+	"fmt"
+	"github.com/jsiebens/operator"
+	"github.com/jsiebens/operator/operatortest"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// startTestServer starts an operatortest server and returns its host and port, and the
+// func which stops it.
+func startTestServer(tb testing.TB, opts ...operatortest.ServerOption) (string, int, func()) {
+	tb.Helper()
+
+	address, stop, err := operatortest.StartTestServer(opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	host, portString, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portString)
+
+	return host, port, stop
+}
+
+// dialTestServer starts an operatortest server and connects to it. The returned func closes
+// the operator and stops the server.
+func dialTestServer(tb testing.TB, opts ...operator.Option) (*operator.SSHOperator, func()) {
+	tb.Helper()
+
+	host, port, stop := startTestServer(tb)
+
+	op, err := operator.Dial(host, port, "test", ssh.Password("test"), opts...)
+	if err != nil {
+		stop()
+		tb.Fatal(err)
+	}
+
+	return op, func() {
+		op.Close()
+		stop()
+	}
+}
+
+// TestConcurrentExecute runs commands from many goroutines over one SSHOperator. Run it with
+// -race to check the operator for data races.
+func TestConcurrentExecute(t *testing.T) {
+	op, stop := dialTestServer(t)
+	defer stop()
+
+	const commands = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, commands)
+	for i := 0; i < commands; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			want := fmt.Sprintf("command %d", i)
+			res, err := op.Execute("echo " + want)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if got := string(res.StdOut); got != want+"\n" {
+				errs <- fmt.Errorf("echo %s: got output %q", want, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHost(t *testing.T) {
+	_, port, stop := startTestServer(t)
+	defer stop()
+
+	auth := []ssh.AuthMethod{ssh.Password("test")}
+	for _, target := range []struct {
+		spec operator.HostSpec
+		want string
+	}{
+		{operator.HostSpec{Host: "localhost", Port: port, User: "test", Auth: auth}, "localhost:" + strconv.Itoa(port)},
+		{operator.HostSpec{Host: "localhost", Port: port, User: "test", Auth: auth, Alias: "web1"}, "web1"},
+	} {
+		op, err := operator.DialVia(nil, target.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := op.Host(); got != target.want {
+			t.Errorf("expected host %s, got %s", target.want, got)
+		}
+		op.Close()
+	}
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrIncorrectSudoPassword = errors.New("sudo: incorrect password")
+
+// sudoPrompt replaces the sudo password prompt, so it can be recognised and stripped from stderr.
+const sudoPrompt = "[operator] sudo password: "
+
+type executeFunc func(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (CommandRes, error)
+
+// executeSudo runs command as root with sudo, writing password to the standard input of sudo.
+// Cached credentials are ignored so the password is always consumed by sudo, and the command
+// itself gets an empty standard input so it never sees the password. A positive limit caps
+// the buffered output like Options.MaxOutputBytes.
+func executeSudo(execute executeFunc, command string, password string, limit int64) (CommandRes, error) {
+	stdout := outputBuffer{limit: limit}
+	stderr := outputBuffer{limit: limit}
+
+	prompt := []byte(sudoPrompt)
+	sudo := fmt.Sprintf("sudo -S -k -p %s -- sh -c %s", shellQuote(sudoPrompt), shellQuote("exec </dev/null; "+command))
+	stdErrWriter := &stripWriter{w: os.Stderr, strip: prompt}
+
+	res, err := execute(sudo, strings.NewReader(password+"\n"), io.MultiWriter(os.Stdout, &stdout), io.MultiWriter(stdErrWriter, &stderr))
+
+	res.StdOut = stdout.Bytes()
+	res.StdErr = bytes.Replace(stderr.Bytes(), prompt, nil, -1)
+	res.Truncated = stdout.truncated || stderr.truncated
+
+	if err != nil && bytes.Count(stderr.Bytes(), prompt) > 1 {
+		return res, ErrIncorrectSudoPassword
+	}
+
+	return res, withStderr(err, command, res.StdErr)
+}
+
+// stripWriter removes every occurrence of strip from the data written to w.
+type stripWriter struct {
+	w     io.Writer
+	strip []byte
+}
+
+func (s *stripWriter) Write(p []byte) (int, error) {
+	if _, err := s.w.Write(bytes.Replace(p, s.strip, nil, -1)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
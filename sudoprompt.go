@@ -0,0 +1,86 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrSudoPasswordRequired is returned when a command without standard input waits at a sudo
+// password prompt. Use ExecuteSudo to run commands which need the password.
+var ErrSudoPasswordRequired = errors.New("command requires sudo password but none was provided")
+
+// sudoPromptPattern matches the default password prompt of sudo at the end of the output.
+var sudoPromptPattern = regexp.MustCompile(`(^|\n)\[sudo\] password for [^\n]*: ?$`)
+
+// sudoPromptTimeout is how long a command may wait at a sudo password prompt without writing
+// anything else before it is aborted.
+const sudoPromptTimeout = 3 * time.Second
+
+// maxPromptTail is the number of bytes at the end of each output stream searched for a prompt.
+const maxPromptTail = 256
+
+// sudoPromptWatcher aborts a command that waits at a sudo password prompt, which nobody can
+// answer when the command has no standard input.
+type sudoPromptWatcher struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	abort   func()
+	aborted bool
+}
+
+// watch returns a writer which copies to w and watches the output for the prompt.
+func (p *sudoPromptWatcher) watch(w io.Writer) io.Writer {
+	return &promptWriter{w: w, watcher: p}
+}
+
+func (p *sudoPromptWatcher) observe(tail []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// any further output means the command is not, or no longer, waiting at the prompt
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !p.aborted && sudoPromptPattern.Match(tail) {
+		p.timer = time.AfterFunc(sudoPromptTimeout, p.fire)
+	}
+}
+
+func (p *sudoPromptWatcher) fire() {
+	p.mu.Lock()
+	p.aborted = true
+	p.mu.Unlock()
+
+	p.abort()
+}
+
+// stop stops watching, and reports whether the command was aborted at a prompt.
+func (p *sudoPromptWatcher) stop() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	return p.aborted
+}
+
+type promptWriter struct {
+	w       io.Writer
+	watcher *sudoPromptWatcher
+	tail    []byte
+}
+
+func (p *promptWriter) Write(b []byte) (int, error) {
+	p.tail = append(p.tail, b...)
+	if len(p.tail) > maxPromptTail {
+		p.tail = append([]byte(nil), p.tail[len(p.tail)-maxPromptTail:]...)
+	}
+	p.watcher.observe(p.tail)
+
+	return p.w.Write(b)
+}
@@ -0,0 +1,124 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// SyncOptions configures Sync.
+type SyncOptions struct {
+	// Checksum compares files of the same size by their SHA-256 checksums instead of their
+	// modification times, e.g. when the local files are checked out anew for every deploy.
+	Checksum bool
+	// Delete removes remote files and directories below remoteDir which do not exist locally.
+	Delete bool
+	// Mode is the octal permission string of uploaded files, e.g. "0644". When empty, the
+	// permissions of the local files are used.
+	Mode string
+}
+
+// syncDir uploads the files below localDir which differ from the remote ones below remoteDir
+// with op. list returns what is below remoteDir by slash-separated relative path, and
+// remoteSum the checksum of a remote file. It returns the remote paths of the uploaded and
+// removed files.
+func syncDir(op CommandOperator, localDir string, remoteDir string, opts SyncOptions, list func(remoteDir string) (map[string]os.FileInfo, error), remoteSum func(remotePath string) (string, error)) ([]string, error) {
+	root := expandPath(localDir)
+
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	remote, err := list(remoteDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list %s", remoteDir)
+	}
+
+	if err := op.MkdirAll(remoteDir, "0755"); err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	local := map[string]bool{}
+
+	err = filepath.Walk(root, func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, current)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		local[rel] = true
+		target := path.Join(remoteDir, rel)
+
+		switch {
+		case info.IsDir():
+			if existing, ok := remote[rel]; ok && existing.IsDir() {
+				return nil
+			}
+			return op.MkdirAll(target, "0755")
+		case info.Mode().IsRegular():
+			same, err := sameFile(current, info, target, remote[rel], opts.Checksum, remoteSum)
+			if err != nil || same {
+				return err
+			}
+			if err := op.UploadFileWithOptions(current, target, UploadOptions{Mode: opts.Mode, PreserveAttrs: true, Atomic: true}); err != nil {
+				return err
+			}
+			changed = append(changed, target)
+			return nil
+		default:
+			warnf("skipping %s: not a regular file", current)
+			return nil
+		}
+	})
+	if err != nil || !opts.Delete {
+		return changed, err
+	}
+
+	// in reverse order, the contents of a directory are removed before the directory itself
+	var removed []string
+	for rel := range remote {
+		if !local[rel] {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(removed)))
+
+	for _, rel := range removed {
+		target := path.Join(remoteDir, rel)
+		if err := op.Remove(target); err != nil {
+			return changed, err
+		}
+		changed = append(changed, target)
+	}
+
+	return changed, nil
+}
+
+// sameFile reports whether the remote file described by remote, which is nil when missing,
+// matches the local one.
+func sameFile(localPath string, local os.FileInfo, remotePath string, remote os.FileInfo, checksum bool, remoteSum func(string) (string, error)) (bool, error) {
+	if remote == nil || !remote.Mode().IsRegular() || remote.Size() != local.Size() {
+		return false, nil
+	}
+	if !checksum {
+		// SFTP transfers modification times in whole seconds
+		return remote.ModTime().Unix() == local.ModTime().Unix(), nil
+	}
+
+	want, err := fileSHA256(localPath)
+	if err != nil {
+		return false, err
+	}
+	got, err := remoteSum(remotePath)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to compute checksum of %s", remotePath)
+	}
+	return got == want, nil
+}
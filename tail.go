@@ -0,0 +1,44 @@
+package operator
+
+import (
+	"context"
+	"golang.org/x/crypto/ssh"
+)
+
+func tailCommand(remotePath string) string {
+	return "tail -f " + shellQuote(remotePath)
+}
+
+// tail runs follow with a context which is done when either parent or ctx is done. ctx being
+// done is how a tail ends, so then no error is returned.
+func tail(parent context.Context, ctx context.Context, follow func(ctx context.Context) error) error {
+	followCtx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-followCtx.Done():
+		}
+	}()
+
+	err := follow(followCtx)
+	if ctx.Err() != nil && parent.Err() == nil {
+		return nil
+	}
+	return err
+}
+
+// requestPlainPty allocates a pseudo-terminal which neither echoes input nor translates
+// newlines, so the output of the command arrives unchanged. For the tail command, it makes
+// the server hang it up when the session is closed, also when it does not support signals.
+func requestPlainPty(sess *ssh.Session) error {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.ONLCR:         0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	return sess.RequestPty("dumb", 24, 80, modes)
+}
@@ -0,0 +1,22 @@
+package operator
+
+import (
+	"time"
+)
+
+// Timing holds how long connecting to a host took, to find the slow hosts of a large run.
+type Timing struct {
+	// DialDuration is the time it took to open the network connection to the host, through the
+	// last jump host if there are any.
+	DialDuration time.Duration
+	// HandshakeDuration is the time the SSH handshake with the host took, including
+	// authentication.
+	HandshakeDuration time.Duration
+}
+
+// Timing returns how long connecting to the host took. When the connection was retried, only
+// the successful attempt is measured. It is zero for an operator created from an existing
+// client, and DialDuration is zero for one created from an existing connection.
+func (s SSHOperator) Timing() Timing {
+	return s.timing
+}
@@ -0,0 +1,48 @@
+package operator
+
+import (
+	"context"
+)
+
+// Span describes an operation reported to a TraceFunc. ExitCode and Bytes are set once the
+// operation ended, before the function returned by the TraceFunc is called.
+type Span struct {
+	// Name is the kind of operation: "Dial", "Execute" or "Upload".
+	Name string
+	// Host is the address of the remote host, or "localhost" for a LocalOperator.
+	Host string
+	// Command is the command run by an Execute span.
+	Command string
+	// Path is the local file of an Upload span, and empty when uploading from an io.Reader.
+	Path string
+	// RemotePath is the destination of an Upload span.
+	RemotePath string
+	// ExitCode is the exit code of the command run by an Execute span.
+	ExitCode int
+	// Bytes is the number of bytes transferred by an Upload span.
+	Bytes int64
+}
+
+// TraceFunc is called when an operation starts, and returns the function which is called
+// with its error when it ends, e.g. to start and end a span of a tracing library. ctx is the
+// context of the operator, or the one passed to DialContext.
+type TraceFunc func(ctx context.Context, span *Span) (end func(err error))
+
+// WithTracer reports every dial, executed command and upload to trace, without tying the
+// package to a particular tracing library.
+func WithTracer(trace TraceFunc) Option {
+	return func(o *Options) {
+		o.Tracer = trace
+	}
+}
+
+// trace reports span to the configured Tracer, and returns the function ending it.
+func (o *Options) trace(ctx context.Context, span *Span) func(err error) {
+	if o.Tracer == nil {
+		return func(error) {}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return o.Tracer(ctx, span)
+}
@@ -0,0 +1,223 @@
+package operator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// UploadOptions configures UploadWithOptions and UploadFileWithOptions.
+type UploadOptions struct {
+	// Mode is the octal permission string of the uploaded file, e.g. "0644". When empty, the
+	// permissions of the local file are used if PreserveAttrs is set, and 0644 otherwise.
+	Mode string
+	// MkdirParents creates the missing parent directories of the remote path like mkdir -p.
+	// Without it, the upload fails when the parent directory does not exist. Upload, UploadFile,
+	// WriteFile and UploadN always create them.
+	MkdirParents bool
+	// DirMode is the octal permission string of the parent directories created with
+	// MkdirParents. When empty, remote hosts apply their defaults and a LocalOperator uses 0755.
+	DirMode string
+	// PreserveAttrs gives the uploaded file the permissions and modification time of the local
+	// file, like scp -p. An explicit Mode still takes precedence over the local permissions.
+	// It has no effect when uploading from an io.Reader.
+	PreserveAttrs bool
+	// Progress, when set, is called periodically while the file is uploaded. The total is the
+	// size of the local file, or -1 when uploading from an io.Reader.
+	Progress ProgressFunc
+	// Atomic writes the data to a temporary file next to the remote path, which is renamed into
+	// place once the transfer succeeded and removed on failure, so an interrupted upload never
+	// leaves a truncated file behind. Upload, UploadFile and UploadDir always upload atomically.
+	// Without it, the remote file is overwritten in place, which keeps its owner and hard links.
+	Atomic bool
+	// Owner and Group change the owner and group of the uploaded file with chown once the
+	// transfer completed. Both accept names as well as numeric IDs; when empty, the owner or
+	// group is left alone.
+	Owner string
+	Group string
+	// Sudo runs chown as root, which is needed to give a file away to another user, with sudo
+	// or the tool set with WithElevation. SudoPassword is passed to it when set; otherwise it
+	// must not ask for a password.
+	Sudo         bool
+	SudoPassword string
+	// Offset resumes an interrupted UploadAt: the first Offset bytes of the source are expected
+	// on the remote file already, which is truncated to Offset and written from there on. It
+	// cannot be combined with Atomic, and is ignored by the other upload methods.
+	Offset int64
+
+	// uploaded counts the bytes read from the source for UploadN and UploadFileN.
+	uploaded *countingReader
+}
+
+// tempName returns the name of the temporary file of an atomic upload to a file named base,
+// e.g. .app.conf.3f9a1c0d2b7e4a55.tmp, so concurrent uploads to the same path and existing
+// files do not clash.
+func tempName(base string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", errors.Wrap(err, "unable to generate a temporary file name")
+	}
+	return "." + base + "." + hex.EncodeToString(suffix) + ".tmp", nil
+}
+
+// resumeSource returns the part of source after Offset, reporting Progress over all size
+// bytes so a resumed upload continues where the interrupted one stopped.
+func (o UploadOptions) resumeSource(source io.ReaderAt, size int64, remotePath string) (io.Reader, error) {
+	if o.Offset < 0 || o.Offset > size {
+		return nil, errors.Errorf("invalid offset %d for uploading %d bytes to %s", o.Offset, size, remotePath)
+	}
+	if o.Offset > 0 && o.Atomic {
+		return nil, errors.Errorf("unable to resume the upload of %s: atomic uploads cannot be resumed", remotePath)
+	}
+
+	r := io.NewSectionReader(source, o.Offset, size-o.Offset)
+	if o.Progress == nil {
+		return r, nil
+	}
+	return &progressReader{r: r, total: size, progress: o.Progress, transferred: o.Offset}, nil
+}
+
+// resumableFile is implemented by both *os.File and *sftp.File.
+type resumableFile interface {
+	io.WriteSeeker
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Chmod(mode os.FileMode) error
+	Close() error
+}
+
+// resumeFile writes source to destination from offset on, after checking that the first
+// offset bytes were uploaded before, and closes destination.
+func resumeFile(destination resumableFile, source io.Reader, remotePath string, mode os.FileMode, offset int64) error {
+	info, err := destination.Stat()
+	if err == nil && info.Size() < offset {
+		err = errors.Errorf("unable to resume the upload of %s at offset %d: the file has only %d bytes", remotePath, offset, info.Size())
+	}
+	if err == nil {
+		err = destination.Truncate(offset)
+	}
+	if err == nil {
+		_, err = destination.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(destination, source)
+	}
+	if err == nil {
+		err = destination.Chmod(mode)
+	}
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// uploadN runs upload like Upload or UploadFile, and returns the number of bytes read from
+// the source.
+func uploadN(mode string, upload func(opts UploadOptions) error) (int64, error) {
+	counter := &countingReader{}
+	err := upload(UploadOptions{Mode: mode, Atomic: true, MkdirParents: true, uploaded: counter})
+	return counter.n, err
+}
+
+// counted returns source, counting the bytes read from it when the upload was started by
+// uploadN.
+func (o UploadOptions) counted(source io.Reader) io.Reader {
+	if o.uploaded == nil {
+		return source
+	}
+	o.uploaded.r = source
+	return o.uploaded
+}
+
+// fileMode returns the permissions for the uploaded file. info describes the local file,
+// and is nil when uploading from an io.Reader.
+func (o UploadOptions) fileMode(info os.FileInfo) (os.FileMode, error) {
+	if o.Mode != "" {
+		return ParseMode(o.Mode)
+	}
+	if o.PreserveAttrs && info != nil {
+		return info.Mode().Perm(), nil
+	}
+	return 0644, nil
+}
+
+// dirMode returns the permissions of the parent directories created for the uploaded file,
+// or zero when DirMode is empty or MkdirParents is not set.
+func (o UploadOptions) dirMode() (os.FileMode, error) {
+	if o.DirMode == "" || !o.MkdirParents {
+		return 0, nil
+	}
+	return ParseMode(o.DirMode)
+}
+
+// modTime returns the modification time to apply to the uploaded file, or the zero time
+// when it should be left alone.
+func (o UploadOptions) modTime(info os.FileInfo) time.Time {
+	if o.PreserveAttrs && info != nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// chown applies Owner and Group to remotePath, running chown with the executeFunc of elevate,
+// or as root with elevate when Sudo is set.
+func (o UploadOptions) chown(elevate elevator, remotePath string) error {
+	if o.Owner == "" && o.Group == "" {
+		return nil
+	}
+
+	owner := o.Owner
+	if o.Group != "" {
+		owner += ":" + o.Group
+	}
+	command := "chown -- " + shellQuote(owner) + " " + shellQuote(remotePath)
+
+	var res CommandRes
+	var err error
+	stderr := bytes.Buffer{}
+
+	switch {
+	case o.Sudo && o.SudoPassword != "":
+		res, err = elevate.run(command, o.SudoPassword)
+		stderr.Write(res.StdErr)
+	case o.Sudo:
+		res, err = elevate.runWithoutPassword(command)
+		stderr.Write(res.StdErr)
+	default:
+		_, err = elevate.execute(command, nil, nil, &stderr)
+	}
+
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrIncorrectSudoPassword) {
+		return errors.Wrapf(err, "unable to change the owner of %s to %s", remotePath, owner)
+	}
+
+	message := strings.TrimSpace(stderr.String())
+	if !o.Sudo && strings.Contains(message, "Operation not permitted") {
+		message += " (changing the owner requires root privileges, set Sudo to run chown with sudo)"
+	}
+	return errors.Errorf("unable to change the owner of %s to %s: %s", remotePath, owner, message)
+}
+
+// openLocal opens the local file at path for uploading.
+func openLocal(path string) (*os.File, os.FileInfo, error) {
+	source, err := os.Open(expandPath(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := source.Stat()
+	if err != nil {
+		source.Close()
+		return nil, nil, err
+	}
+
+	return source, info, nil
+}
@@ -0,0 +1,50 @@
+package operator_test
+
+import (
+	"bytes"
+	"github.com/jsiebens/operator"
+	"github.com/jsiebens/operator/operatortest"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUploadDiskFull uploads to a test server whose disk fills up after 16 KiB, and expects
+// the failure to be reported as a DiskFullError over SFTP as well as scp.
+func TestUploadDiskFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "operator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name string
+		opts operator.UploadOptions
+		with []operator.Option
+	}{
+		{"sftp", operator.UploadOptions{Mode: "0644"}, nil},
+		{"sftp atomic", operator.UploadOptions{Mode: "0644", Atomic: true}, nil},
+		{"scp", operator.UploadOptions{Mode: "0644"}, []operator.Option{operator.WithSFTPSubsystem("none")}},
+	} {
+		host, port, stop := startTestServer(t, operatortest.WithDiskSpace(16*1024))
+
+		op, err := operator.Dial(host, port, "test", ssh.Password("test"), test.with...)
+		if err != nil {
+			stop()
+			t.Fatal(err)
+		}
+
+		err = op.UploadWithOptions(bytes.NewReader(make([]byte, 64*1024)), filepath.Join(dir, "file"), test.opts)
+		op.Close()
+		stop()
+
+		var diskFullErr *operator.DiskFullError
+		if !errors.As(err, &diskFullErr) {
+			t.Errorf("%s: expected a DiskFullError, got %v", test.name, err)
+		}
+	}
+}
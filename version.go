@@ -0,0 +1,43 @@
+package operator
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// DefaultClientVersion identifies this package to the server in the SSH handshake when no
+// client version is configured.
+const DefaultClientVersion = "SSH-2.0-operator"
+
+// WithClientVersion sets the identification string sent to the server in the SSH handshake,
+// e.g. to match an allow-list on the server. It must start with "SSH-2.0-"; a malformed
+// version fails before connecting.
+func WithClientVersion(version string) Option {
+	return func(o *Options) {
+		o.ClientVersion = version
+	}
+}
+
+// clientVersion returns the configured client version, or DefaultClientVersion, after
+// checking it against RFC 4253: the "SSH-2.0-" prefix followed by a software version, and
+// at most 253 printable ASCII characters, leaving room for the trailing CR LF.
+func (o *Options) clientVersion() (string, error) {
+	version := o.ClientVersion
+	if version == "" {
+		return DefaultClientVersion, nil
+	}
+
+	if !strings.HasPrefix(version, "SSH-2.0-") || len(version) == len("SSH-2.0-") {
+		return "", errors.Errorf("invalid client version %q: must start with SSH-2.0- followed by the software version", version)
+	}
+	if len(version) > 253 {
+		return "", errors.Errorf("invalid client version %q: longer than 253 characters", version)
+	}
+	for _, c := range version {
+		if c < ' ' || c > '~' {
+			return "", errors.Errorf("invalid client version %q: must consist of printable ASCII characters", version)
+		}
+	}
+
+	return version, nil
+}